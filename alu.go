@@ -0,0 +1,130 @@
+package main
+
+// Operations performed by the ALU in the execute stage
+type ALUOp int
+
+const (
+	ALUAdd ALUOp = iota
+	ALUSub
+	ALUAnd
+	ALUOr
+	ALUXor
+	ALUNor
+	ALUSlt
+	ALUSltu
+	ALUSll
+	ALUSrl
+	ALUSra
+	// Second operand to the upper half of the register, as in lui
+	ALUUpper
+)
+
+// Where an instruction takes its ALU operands from and writes the result to
+type operandFormat int
+
+const (
+	// add R1 R2 R3: R1 = R2 op R3
+	rFormat operandFormat = iota
+	// sll R1 R2 4: R1 = R2 op 4
+	shiftFormat
+	// addi R1 R2 5: R1 = R2 op 5. See iOperands for the older addi and subi
+	// forms, and immediateOperand for their last operand
+	iFormat
+	// lui R1 5: R1 = op 5
+	upperFormat
+)
+
+// ALU control, decoded from the opcode
+type aluControl struct {
+	op     ALUOp
+	format operandFormat
+}
+
+var aluControls = map[Opcode]aluControl{
+	ADD:   {ALUAdd, rFormat},
+	SUB:   {ALUSub, rFormat},
+	AND:   {ALUAnd, rFormat},
+	OR:    {ALUOr, rFormat},
+	XOR:   {ALUXor, rFormat},
+	NOR:   {ALUNor, rFormat},
+	SLT:   {ALUSlt, rFormat},
+	SLTU:  {ALUSltu, rFormat},
+	SLLV:  {ALUSll, rFormat},
+	SRLV:  {ALUSrl, rFormat},
+	SRAV:  {ALUSra, rFormat},
+	SLL:   {ALUSll, shiftFormat},
+	SRL:   {ALUSrl, shiftFormat},
+	SRA:   {ALUSra, shiftFormat},
+	ADDI:  {ALUAdd, iFormat},
+	SUBI:  {ALUSub, iFormat},
+	ANDI:  {ALUAnd, iFormat},
+	ORI:   {ALUOr, iFormat},
+	XORI:  {ALUXor, iFormat},
+	SLTI:  {ALUSlt, iFormat},
+	SLTIU: {ALUSltu, iFormat},
+	LUI:   {ALUUpper, upperFormat},
+}
+
+// Integer ALU as wide as the registers. Results wrap around at the width.
+type ALU struct {
+	width int
+}
+
+func NewALU(width int) ALU {
+	return ALU{width: width}
+}
+
+// Bits of the value in the ALU width, as an unsigned number
+func (a ALU) unsigned(v int64) uint64 {
+	return uint64(v) & (1<<a.width - 1)
+}
+
+// Value truncated to the ALU width and sign extended
+func (a ALU) truncate(v int64) int64 {
+	shift := 64 - a.width
+	return v << shift >> shift
+}
+
+// Shift amounts use the lower 5 bits, as in MIPS
+func (a ALU) shamt(v int64) uint64 {
+	return uint64(v) & 0x1F
+}
+
+func (a ALU) Execute(op ALUOp, x, y int64) int64 {
+	var r int64
+	switch op {
+	case ALUAdd:
+		r = x + y
+	case ALUSub:
+		r = x - y
+	case ALUAnd:
+		r = x & y
+	case ALUOr:
+		r = x | y
+	case ALUXor:
+		r = x ^ y
+	case ALUNor:
+		r = ^(x | y)
+	case ALUSlt:
+		r = boolWord(x < y)
+	case ALUSltu:
+		r = boolWord(a.unsigned(x) < a.unsigned(y))
+	case ALUSll:
+		r = x << a.shamt(y)
+	case ALUSrl:
+		// Zeros come in from the left of the register, not of the int64
+		r = int64(a.unsigned(x) >> a.shamt(y))
+	case ALUSra:
+		r = x >> a.shamt(y)
+	case ALUUpper:
+		r = y << (a.width / 2)
+	}
+	return a.truncate(r)
+}
+
+func boolWord(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Position in the source file, both starting at 1
+type Position struct {
+	Line int
+	Col  int
+}
+
+type Token struct {
+	Text string
+	Pos  Position
+}
+
+// Comments start with # or ; and go to the end of the line, unless quoted
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case (c == '#' || c == ';') && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Splits a line in tokens separated by spaces, tabs and commas. Quoted
+// strings are a single token, quotes included
+func lex(line string, num int) []Token {
+	tokens := make([]Token, 0)
+	start := -1
+	quoted := false
+	for col := 0; col <= len(line); col++ {
+		c := byte(' ')
+		if col < len(line) {
+			c = line[col]
+		}
+		switch {
+		case quoted && c == '\\' && col+1 < len(line):
+			col++
+			continue
+		case c == '"':
+			quoted = !quoted
+		case quoted && col < len(line):
+			continue
+		case c == ' ' || c == '\t' || c == ',' || c == '\r':
+			if start >= 0 {
+				tokens = append(tokens, Token{Text: line[start:col], Pos: Position{Line: num, Col: start + 1}})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = col
+		}
+	}
+	return tokens
+}
+
+type Diagnostic struct {
+	File    string
+	Pos     Position
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Pos.Line, d.Pos.Col, d.Message)
+}
+
+// Every problem found in a program, in source order
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, 0, len(d))
+	for _, diag := range d {
+		lines = append(lines, diag.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Kinds of operand an instruction expects
+type operandKind int
+
+const (
+	registerOperand operandKind = iota
+	// Register, data label or signed immediate, as the last operand of addi
+	// and subi
+	valueOperand
+	// 16 bits immediate, sign extended
+	signedOperand
+	// 16 bits immediate, zero extended
+	unsignedOperand
+	// Immediate loaded into the upper half of the register by lui
+	upperOperand
+	// 5 bits shift amount
+	shamtOperand
+	// offset(base), see effectiveAddress
+	addressOperand
+	// Label of an instruction, as branch target
+	labelOperand
+	// Integer stored by .fill
+	integerOperand
+)
+
+var operandKinds = map[Opcode][]operandKind{
+	ADD:          {registerOperand, registerOperand, registerOperand},
+	SUB:          {registerOperand, registerOperand, registerOperand},
+	ADDI:         {registerOperand, registerOperand, valueOperand},
+	SUBI:         {registerOperand, registerOperand, valueOperand},
+	AND:          {registerOperand, registerOperand, registerOperand},
+	OR:           {registerOperand, registerOperand, registerOperand},
+	XOR:          {registerOperand, registerOperand, registerOperand},
+	NOR:          {registerOperand, registerOperand, registerOperand},
+	SLT:          {registerOperand, registerOperand, registerOperand},
+	SLTU:         {registerOperand, registerOperand, registerOperand},
+	SLL:          {registerOperand, registerOperand, shamtOperand},
+	SRL:          {registerOperand, registerOperand, shamtOperand},
+	SRA:          {registerOperand, registerOperand, shamtOperand},
+	SLLV:         {registerOperand, registerOperand, registerOperand},
+	SRLV:         {registerOperand, registerOperand, registerOperand},
+	SRAV:         {registerOperand, registerOperand, registerOperand},
+	ANDI:         {registerOperand, registerOperand, unsignedOperand},
+	ORI:          {registerOperand, registerOperand, unsignedOperand},
+	XORI:         {registerOperand, registerOperand, unsignedOperand},
+	SLTI:         {registerOperand, registerOperand, signedOperand},
+	SLTIU:        {registerOperand, registerOperand, signedOperand},
+	LUI:          {registerOperand, upperOperand},
+	MULT:         {registerOperand, registerOperand},
+	MULTU:        {registerOperand, registerOperand},
+	DIV:          {registerOperand, registerOperand},
+	DIVU:         {registerOperand, registerOperand},
+	MFHI:         {registerOperand},
+	MFLO:         {registerOperand},
+	LW:           {registerOperand, addressOperand},
+	SW:           {registerOperand, addressOperand},
+	BEQ:          {registerOperand, registerOperand, labelOperand},
+	BNE:          {registerOperand, registerOperand, labelOperand},
+	BLEZ:         {registerOperand, labelOperand},
+	BGTZ:         {registerOperand, labelOperand},
+	BLTZ:         {registerOperand, labelOperand},
+	BGEZ:         {registerOperand, labelOperand},
+	J:            {labelOperand},
+	JAL:          {labelOperand},
+	JR:           {registerOperand},
+	JALR:         {registerOperand},
+	HALT:         {},
+	NOOP:         {},
+	Opcode(FILL): {integerOperand},
+}
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func isMnemonic(s string) bool {
+	_, ok := operandKinds[Opcode(s)]
+	return ok || isPseudo(s) || isDataDirective(s) || s == textSegment || s == dataSegment
+}
+
+// A label is followed by the opcode. Anything else after an unknown first
+// token, like a register, means the first one is the opcode
+func isOpcodeLike(s string) bool {
+	if _, ok := parseRegister(s); ok {
+		return false
+	}
+	return isMnemonic(s) || labelPattern.MatchString(s)
+}
+
+// Bits of the immediate field of I-type instructions
+const immediateBits = 16
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseInt(strings.TrimPrefix(s, "-"), 0, 64)
+	return err == nil
+}
+
+// Value of an immediate operand, decimal or hexadecimal, checked against the
+// field width. Hexadecimal values are the bits of the field, so 0xFFFF is -1
+// when sign extended. Returns false if the operand is not a number, and so
+// may be a register or label when the kind allows it.
+func immediate(op Opcode, kind operandKind, s string) (int64, bool, error) {
+	if kind != valueOperand && kind != signedOperand && kind != unsignedOperand && kind != upperOperand && kind != shamtOperand {
+		return 0, false, nil
+	}
+	// Bare numbers are registers for addi and subi only when prefixed
+	if !isNumber(s) {
+		return 0, false, nil
+	}
+
+	bits := immediateBits
+	switch kind {
+	case upperOperand:
+		bits = 8 * wordSize / 2
+	case shamtOperand:
+		bits = 5
+	}
+	signed := kind == valueOperand || kind == signedOperand
+
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid immediate %q", s)
+	}
+
+	lo, hi := int64(0), int64(1)<<bits-1
+	if signed {
+		lo, hi = -1<<(bits-1), 1<<(bits-1)-1
+	}
+	if op == SUBI {
+		// Encoded as addi with the immediate negated
+		lo, hi = lo+1, hi+1
+	}
+
+	hex := strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")
+	if hex && signed && v < 1<<bits {
+		// Sign extend the field bits
+		shift := 64 - bits
+		v = v << shift >> shift
+	}
+	if v < lo || v > hi {
+		return 0, true, fmt.Errorf("immediate %s out of range for %s, expected %d to %d", s, op, lo, hi)
+	}
+	return v, true, nil
+}
+
+// Values that fit a memory word, either signed or unsigned
+func fitsWord(v int) bool {
+	bits := 8 * wordSize
+	return v >= -1<<(bits-1) && v < 1<<bits
+}
+
+// One line of the program: an instruction or a .fill directive, optionally
+// labeled
+type Statement struct {
+	Pos         Position
+	Label       string
+	Instruction *Instruction
+	operands    []Token
+	// Pseudo-instruction expanded into this statement and the ones after it
+	pseudo Token
+	// Why the instruction has no machine code, if it has none
+	encodeErr error
+}
+
+// Assembled program. Each statement takes a PC, in order, starting at 1.
+// Statements keep their source line, for diagnostics and the TUI
+type Program struct {
+	File       string
+	Statements []*Statement
+	Labels     map[string]int // Label: PC
+	Data       map[string]int // Label: address
+	Items      []*DataItem    // Data memory contents, stored by Load
+	DataSize   int            // Bytes taken by the data
+}
+
+// Parses and validates the whole source, reporting every problem found
+// instead of stopping at the first one
+func Assemble(file, src string) (*Program, error) {
+	p := &Program{
+		File:   file,
+		Labels: make(map[string]int),
+		Data:   make(map[string]int),
+	}
+	var diags Diagnostics
+	report := func(pos Position, format string, v ...any) {
+		diags = append(diags, Diagnostic{File: file, Pos: pos, Message: fmt.Sprintf(format, v...)})
+	}
+
+	// For some reason, bytes to string cast cause an extra '\n'
+	content, _ := strings.CutSuffix(src, "\n")
+
+	// First pass: statements, data and labels, so they can be used before
+	// declared. Blank lines and comments take no PC, and neither do the
+	// directives in the .data segment
+	address := 0
+	segment := textSegment
+	lines := make(map[string]int) // Label: source line
+	var pending []Token           // Labels alone on their line
+
+	// Labels followed by no statement in their segment label the next free PC
+	// or address
+	bindPending := func() {
+		for _, l := range pending {
+			if segment == dataSegment {
+				p.Data[l.Text] = address
+			} else {
+				p.Labels[l.Text] = len(p.Statements) + 1
+			}
+		}
+		pending = nil
+	}
+	for n, line := range strings.Split(content, "\n") {
+		line = stripComment(line)
+		num, pc := n+1, len(p.Statements)+1
+		tokens := lex(line, num)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		// A label comes before the opcode, as "loop add" or "loop: add". With
+		// the colon it may also be alone on its line, labeling what follows
+		labels := pending
+		pending = nil
+		label, hasLabel := Token{}, false
+		if name, ok := strings.CutSuffix(tokens[0].Text, ":"); ok && name != "" {
+			label, hasLabel = Token{Text: name, Pos: tokens[0].Pos}, true
+		} else if !isMnemonic(tokens[0].Text) && len(tokens) > 1 && isOpcodeLike(tokens[1].Text) {
+			label, hasLabel = tokens[0], true
+		}
+		if hasLabel {
+			tokens = tokens[1:]
+			if !labelPattern.MatchString(label.Text) {
+				report(label.Pos, "invalid label %q", label.Text)
+			} else if prev, ok := lines[label.Text]; ok {
+				report(label.Pos, "label %q already defined at line %d", label.Text, prev)
+			} else {
+				lines[label.Text] = num
+				labels = append(labels, label)
+			}
+		}
+		if len(tokens) == 0 {
+			pending = labels
+			continue
+		}
+
+		opcode := tokens[0]
+		switch {
+		case opcode.Text == textSegment || opcode.Text == dataSegment:
+			if len(tokens) > 1 {
+				report(opcode.Pos, "%s expects 0 operands, got %d", opcode.Text, len(tokens)-1)
+			}
+			pending = labels
+			bindPending()
+			segment = opcode.Text
+			continue
+
+		case isDataDirective(opcode.Text) && segment == dataSegment:
+			start, next, item := p.layout(opcode, tokens[1:], address, report)
+			for _, l := range labels {
+				p.Data[l.Text] = start
+			}
+			if item != nil {
+				p.Items = append(p.Items, item)
+			}
+			address = next
+			continue
+
+		case isDataDirective(opcode.Text) && opcode.Text != FILL:
+			report(opcode.Pos, "%s is only valid in the %s segment", opcode.Text, dataSegment)
+			continue
+
+		case segment == dataSegment:
+			report(opcode.Pos, "instruction %q in the %s segment", opcode.Text, dataSegment)
+			continue
+		}
+
+		s := &Statement{Pos: opcode.Pos}
+		if hasLabel {
+			s.Pos = label.Pos
+		}
+		if len(labels) > 0 {
+			s.Label = labels[0].Text
+		}
+		for _, l := range labels {
+			p.Labels[l.Text] = pc
+		}
+
+		if ps, ok := pseudoOps[opcode.Text]; ok {
+			operands := tokens[1:]
+			if len(operands) != ps.operands {
+				report(opcode.Pos, "%s expects %d operands, got %d", opcode.Text, ps.operands, len(operands))
+				continue
+			}
+			// Native instructions are filled in once every label is known
+			source := strings.TrimSpace(line[opcode.Pos.Col-1:])
+			parts := ps.size(operands)
+			for part := 1; part <= parts; part++ {
+				native := &Statement{Pos: s.Pos, Instruction: &Instruction{
+					PC:     pc + part - 1,
+					Line:   num,
+					Raw:    strings.TrimSpace(line),
+					Pseudo: source,
+					Part:   part,
+					Parts:  parts,
+				}}
+				if part == 1 {
+					native.Label = s.Label
+					native.pseudo = opcode
+					native.operands = operands
+				}
+				p.Statements = append(p.Statements, native)
+			}
+			continue
+		}
+		if !isMnemonic(opcode.Text) {
+			report(opcode.Pos, "unknown opcode %q", opcode.Text)
+			continue
+		}
+		s.Instruction = &Instruction{Opcode: Opcode(opcode.Text), PC: pc, Line: num, Raw: strings.TrimSpace(line)}
+		s.operands = tokens[1:]
+
+		kinds := operandKinds[s.Instruction.Opcode]
+		if len(s.operands) != len(kinds) {
+			report(opcode.Pos, "%s expects %d operands, got %d", opcode.Text, len(kinds), len(s.operands))
+			continue
+		}
+
+		// Out of the .data segment, .fill still takes a PC, for compatibility
+		if opcode.Text == FILL {
+			if s.Label == "" {
+				report(opcode.Pos, "%s needs a label", FILL)
+			}
+			start, next, item := p.layout(opcode, s.operands, address, report)
+			if item != nil && s.Label != "" {
+				p.Data[s.Label] = start
+				p.Items = append(p.Items, item)
+				address = next
+			}
+		}
+		p.Statements = append(p.Statements, s)
+	}
+	bindPending()
+	p.DataSize = address
+
+	// Pseudo-instructions, now that every label is known
+	for n, s := range p.Statements {
+		if s.pseudo.Text == "" {
+			continue
+		}
+		natives, err := pseudoOps[s.pseudo.Text].expand(s.operands, s.pseudo.Pos, p)
+		if err != nil {
+			report(s.pseudo.Pos, "%v", err)
+			continue
+		}
+		for part, native := range natives {
+			expanded := p.Statements[n+part]
+			expanded.Instruction.Opcode = native.opcode
+			expanded.operands = native.operands
+		}
+	}
+
+	// Second pass: operands, now that every label is known
+	for _, s := range p.Statements {
+		i := s.Instruction
+		ops := []*string{&i.Op1, &i.Op2, &i.Op3}
+		for n, kind := range operandKinds[i.Opcode] {
+			tok := s.operands[n]
+			if imm, ok, err := immediate(i.Opcode, kind, tok.Text); ok {
+				if err != nil {
+					report(tok.Pos, "%v", err)
+					continue
+				}
+				*ops[n] = tok.Text
+				i.Imm, i.Immediate = imm, true
+				continue
+			}
+
+			value, err := p.operand(kind, tok.Text)
+			if err != nil {
+				report(tok.Pos, "%v", err)
+				continue
+			}
+			*ops[n] = value
+			if kind == labelOperand {
+				i.Target = p.Labels[value]
+			}
+		}
+	}
+
+	// Machine code, for the instructions that have one. The others still run,
+	// but can not be assembled into words
+	for _, s := range p.Statements {
+		if s.Instruction.Opcode == Opcode(FILL) {
+			continue
+		}
+		s.Instruction.Encoding, s.encodeErr = Encode(s.Instruction, p.Data)
+	}
+
+	if len(diags) > 0 {
+		sort.SliceStable(diags, func(a, b int) bool {
+			return diags[a].Pos.Line < diags[b].Pos.Line
+		})
+		return nil, diags
+	}
+	return p, nil
+}
+
+// Validated operand, with registers in their canonical name
+func (p *Program) operand(kind operandKind, s string) (string, error) {
+	switch kind {
+	case registerOperand:
+		if r, ok := parseRegister(s); ok {
+			return r, nil
+		}
+		return "", fmt.Errorf("invalid register %q", s)
+
+	case valueOperand:
+		if r, ok := parseRegister(s); ok {
+			return r, nil
+		}
+		if _, ok := p.Data[s]; ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("%q is not a register, data label or immediate", s)
+
+	case signedOperand, unsignedOperand, upperOperand, shamtOperand:
+		return "", fmt.Errorf("invalid immediate %q", s)
+
+	case addressOperand:
+		offset, base, hasBase := strings.Cut(s, "(")
+		if hasBase {
+			if !strings.HasSuffix(base, ")") {
+				return "", fmt.Errorf("invalid address %q, expected offset(base)", s)
+			}
+			r, ok := parseRegister(strings.TrimSuffix(base, ")"))
+			if !ok {
+				return "", fmt.Errorf("invalid register %q", strings.TrimSuffix(base, ")"))
+			}
+			base = "(" + r + ")"
+		}
+		if _, err := strconv.Atoi(offset); err != nil && offset != "" {
+			if _, ok := p.Data[offset]; !ok {
+				return "", fmt.Errorf("invalid offset %q, expected a number or data label", offset)
+			}
+		}
+		return offset + base, nil
+
+	case labelOperand:
+		if _, ok := p.Labels[s]; ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("undefined label %q", s)
+	}
+	return s, nil
+}
+
+// Lays out the data in data memory
+func (p *Program) Load(mem *Memory) error {
+	if p.DataSize > mem.Size() {
+		return fmt.Errorf("Data takes %d bytes, but the memory has %d", p.DataSize, mem.Size())
+	}
+
+	var diags Diagnostics
+	for _, item := range p.Items {
+		for n, v := range item.Values {
+			addr := item.Addr + n*item.Size
+			if err := mem.Store(addr, item.Size, v); err != nil {
+				diags = append(diags, Diagnostic{File: p.File, Pos: item.Pos, Message: err.Error()})
+				break
+			}
+		}
+		Debug("Stored %d values of %d bytes at address %d\n", len(item.Values), item.Size, item.Addr)
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	return nil
+}
+
+// Fresh copy of the instruction at pc, as each fetch goes through the
+// pipeline on its own
+func (p *Program) Fetch(pc int) (*Instruction, bool) {
+	if pc < 1 || pc > len(p.Statements) {
+		return nil, false
+	}
+	s := p.Statements[pc-1].Instruction
+	return &Instruction{
+		Opcode:    s.Opcode,
+		Op1:       s.Op1,
+		Op2:       s.Op2,
+		Op3:       s.Op3,
+		Imm:       s.Imm,
+		Immediate: s.Immediate,
+		Raw:       s.Raw,
+		PC:        s.PC,
+		Line:      s.Line,
+		Target:    s.Target,
+		Encoding:  s.Encoding,
+		Pseudo:    s.Pseudo,
+		Part:      s.Part,
+		Parts:     s.Parts,
+	}, true
+}
+
+// Label of the statement at pc, if it has one
+func (p *Program) LabelAt(pc int) (string, bool) {
+	if pc < 1 || pc > len(p.Statements) {
+		return "", false
+	}
+	label := p.Statements[pc-1].Label
+	return label, label != ""
+}
+
+// file:line of an instruction, to point runtime errors at the source
+func (p *Program) Where(i *Instruction) string {
+	return fmt.Sprintf("%s:%d", p.File, i.Line)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tokens := lex("loop\tadd  R1,R2, R3", 7)
+
+	want := []Token{
+		{"loop", Position{7, 1}},
+		{"add", Position{7, 6}},
+		{"R1", Position{7, 11}},
+		{"R2", Position{7, 14}},
+		{"R3", Position{7, 18}},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("Got %v, want %v", tokens, want)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Token %d = %v, want %v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	src := "addi 0 1 one\nloop add R2,\tR1, R2\nbeq 2 0 done\nj loop\nlw 3 one(R0)\ndone halt\none .fill 1\n"
+
+	p, err := Assemble("prog.s", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pc   int
+		want Instruction
+	}{
+		{1, Instruction{Opcode: ADDI, Op1: "R0", Op2: "R1", Op3: "one"}},
+		{2, Instruction{Opcode: ADD, Op1: "R2", Op2: "R1", Op3: "R2"}},
+		{3, Instruction{Opcode: BEQ, Op1: "R2", Op2: "R0", Op3: "done", Target: 6}},
+		{4, Instruction{Opcode: J, Op1: "loop", Target: 2}},
+		{5, Instruction{Opcode: LW, Op1: "R3", Op2: "one(R0)"}},
+		{6, Instruction{Opcode: HALT}},
+	}
+	for _, tt := range tests {
+		i, ok := p.Fetch(tt.pc)
+		if !ok {
+			t.Fatalf("No instruction at PC %d", tt.pc)
+		}
+		if i.Opcode != tt.want.Opcode || i.Op1 != tt.want.Op1 || i.Op2 != tt.want.Op2 || i.Op3 != tt.want.Op3 || i.Target != tt.want.Target {
+			t.Errorf("PC %d = %+v, want %+v", tt.pc, *i, tt.want)
+		}
+	}
+
+	mem := NewMemory(16, binary.BigEndian)
+	if err := p.Load(mem); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mem.Load(p.Data["one"], wordSize); p.Data["one"] != 0 || v != 1 {
+		t.Errorf("one at %d with %d, want 0 and 1", p.Data["one"], v)
+	}
+}
+
+func TestAssembleDiagnostics(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"ad 2 1 2", `prog.s:1:1: unknown opcode "ad"`},
+		{"loop ad 2 1 2", `prog.s:1:6: unknown opcode "ad"`},
+		{"add 2 1", `prog.s:1:1: add expects 3 operands, got 2`},
+		{"add 2 1 R32", `prog.s:1:9: invalid register "R32"`},
+		{"j loop", `prog.s:1:3: undefined label "loop"`},
+		{"halt\n  addi 0 1 x", `prog.s:2:12: "x" is not a register, data label or immediate`},
+		{"lw 1 4(R2", `prog.s:1:6: invalid address "4(R2", expected offset(base)`},
+		{"x .fill 256", `prog.s:1:9: invalid .fill value "256"`},
+		{"a halt\na noop", `prog.s:2:1: label "a" already defined at line 1`},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble("prog.s", tt.src)
+		var diags Diagnostics
+		if !errors.As(err, &diags) {
+			t.Errorf("%q: expected diagnostics, got %v", tt.src, err)
+			continue
+		}
+		if diags[0].Error() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.src, diags[0], tt.want)
+		}
+	}
+}
+
+func TestAssembleReportsAll(t *testing.T) {
+	_, err := Assemble("prog.s", "j nowhere\nad 1 2 3\nadd 1 2\n")
+
+	var diags Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 3 {
+		t.Fatalf("Got %v, want 3 diagnostics", err)
+	}
+	for i, d := range diags {
+		if d.Pos.Line != i+1 {
+			t.Errorf("Diagnostic %d at line %d, want %d", i, d.Pos.Line, i+1)
+		}
+	}
+}
+
+func TestAssembleComments(t *testing.T) {
+	src := "# Count down\n\nloop addi 1 1 one ; R1 -= 1\n\t# nothing\nbeq 1 0 loop # back\none .fill 1\n"
+
+	p, err := Assemble("prog.s", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Comments and blank lines take no PC
+	lines := map[int]int{1: 3, 2: 5, 3: 6}
+	if len(p.Statements) != len(lines) {
+		t.Fatalf("Got %d statements, want %d", len(p.Statements), len(lines))
+	}
+	for pc, line := range lines {
+		if i, _ := p.Fetch(pc); i.Line != line {
+			t.Errorf("Line of PC %d = %d, want %d", pc, i.Line, line)
+		}
+		if got := p.Statements[pc-1].Instruction.PC; got != pc {
+			t.Errorf("Statement at line %d has PC %d, want %d", line, got, pc)
+		}
+	}
+
+	i, _ := p.Fetch(2)
+	if i.Target != 1 || i.Line != 5 || i.Raw != "beq 1 0 loop" {
+		t.Errorf("Got target %d, line %d, raw %q", i.Target, i.Line, i.Raw)
+	}
+}
+
+func TestAssembleABINames(t *testing.T) {
+	p, err := Assemble("prog.s", "add $t0, $zero, $s1\nlw $ra, -4($sp)\nsw $8 0($fp)\nadd $s8 R31 7\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"add R8 R0 R17", "lw R31 -4(R29)", "sw R8 0(R30)", "add R30 R31 R7"}
+	for pc, w := range want {
+		i, _ := p.Fetch(pc + 1)
+		if i.String() != w {
+			t.Errorf("PC %d = %s, want %s", pc+1, i, w)
+		}
+	}
+
+	_, err = Assemble("prog.s", "add $t10, $zero, $s1")
+	if err == nil || err.Error() != `prog.s:1:5: invalid register "$t10"` {
+		t.Errorf("Got %v", err)
+	}
+}
+
+func TestAssembleImmediates(t *testing.T) {
+	p, err := Assemble("prog.s", "addi R0 R1 -5\naddi R0 R1 0xFFFF\nandi R1 R2 0xFFFF\nori R1 R2 65535\nslti R1 R2 -32768\nlui R1 0xF\naddi R0 R1 R2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		imm       int64
+		immediate bool
+	}{
+		{-5, true},
+		{-1, true}, // Sign extended
+		{0xFFFF, true},
+		{65535, true},
+		{-32768, true},
+		{0xF, true},
+		{0, false}, // Register
+	}
+	for n, tt := range tests {
+		i, _ := p.Fetch(n + 1)
+		if i.Imm != tt.imm || i.Immediate != tt.immediate {
+			t.Errorf("PC %d: imm = %d, %v, want %d, %v", n+1, i.Imm, i.Immediate, tt.imm, tt.immediate)
+		}
+	}
+
+	diags := []struct {
+		src  string
+		want string
+	}{
+		{"addi R0 R1 32768", `prog.s:1:12: immediate 32768 out of range for addi, expected -32768 to 32767`},
+		{"subi R1 R0 -32768", `prog.s:1:12: immediate -32768 out of range for subi, expected -32767 to 32768`},
+		{"andi R0 R1 -1", `prog.s:1:12: immediate -1 out of range for andi, expected 0 to 65535`},
+		{"ori R0 R1 0x10000", `prog.s:1:11: immediate 0x10000 out of range for ori, expected 0 to 65535`},
+		{"xori R0 R1 R2", `prog.s:1:12: invalid immediate "R2"`},
+		{"lui R1 0x10", `prog.s:1:8: immediate 0x10 out of range for lui, expected 0 to 15`},
+	}
+	for _, tt := range diags {
+		_, err := Assemble("prog.s", tt.src)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestAssemblePseudo(t *testing.T) {
+	src := "li $t0, 5\nloop blt $t0, $zero, done\nmove $t1, $t0\nla $t2, ten\nb loop\ndone nop\nten .fill 10\n"
+
+	p, err := Assemble("prog.s", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Native instructions, each with the line of its pseudo-instruction
+	want := []struct {
+		native string
+		line   int
+	}{
+		{"addi R8 R0 5", 1},
+		{"slt R1 R8 R0", 2},
+		{"bne R1 R0 done", 2},
+		{"add R9 R8 R0", 3},
+		{"addi R10 R0 0", 4},
+		{"beq R0 R0 loop", 5},
+		{"noop", 6},
+	}
+	for n, w := range want {
+		i, _ := p.Fetch(n + 1)
+		native := *i
+		native.Pseudo = ""
+		if native.String() != w.native || i.Line != w.line {
+			t.Errorf("PC %d = %s at line %d, want %s at line %d", n+1, native.String(), i.Line, w.native, w.line)
+		}
+	}
+
+	if p.Labels["loop"] != 2 || p.Labels["done"] != 7 {
+		t.Errorf("Labels %v, want loop at 2 and done at 7", p.Labels)
+	}
+	if i, _ := p.Fetch(3); i.String() != "blt $t0, $zero, done (2/2)" {
+		t.Errorf("Got %s", i)
+	}
+}
+
+func TestAssemblePseudoLi(t *testing.T) {
+	setWidth(t, 32)
+
+	p, err := Assemble("prog.s", "li $t0 0x12345\nli $t1 -2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, w := range []string{"lui R8 1", "ori R8 R8 9029", "addi R9 R0 -2"} {
+		i, _ := p.Fetch(n + 1)
+		i.Pseudo = ""
+		if i.String() != w {
+			t.Errorf("PC %d = %s, want %s", n+1, i, w)
+		}
+	}
+
+	setWidth(t, 8)
+	_, err = Assemble("prog.s", "li $t0 300\nla $t1 nowhere\n")
+	want := "prog.s:1:1: invalid li value \"300\"\nprog.s:2:1: undefined data label \"nowhere\""
+	if err == nil || err.Error() != want {
+		t.Errorf("Got %v, want %v", err, want)
+	}
+}
+
+func TestLexStrings(t *testing.T) {
+	line := stripComment(`msg: .asciiz "a, \"b\" # c" # comment`)
+	tokens := lex(line, 1)
+
+	want := []string{"msg:", ".asciiz", `"a, \"b\" # c"`}
+	if len(tokens) != len(want) {
+		t.Fatalf("Got %v, want %v", tokens, want)
+	}
+	for i := range want {
+		if tokens[i].Text != want[i] {
+			t.Errorf("Token %d = %s, want %s", i, tokens[i].Text, want[i])
+		}
+	}
+}
+
+func TestAssembleData(t *testing.T) {
+	src := `.data
+msg: .asciiz "hi, #1"
+nums .half 1, -2
+.align 2
+buf: .space 3
+w .word 5
+end:
+.text
+main:
+  la $t0 msg
+  lw $t1 w(R0)
+  halt
+`
+	p, err := Assemble("prog.s", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Symbol{
+		{"main", textSegment, 1},
+		{"msg", dataSegment, 0},
+		{"nums", dataSegment, 8},
+		{"buf", dataSegment, 12},
+		{"w", dataSegment, 15},
+		{"end", dataSegment, 16},
+	}
+	symbols := p.Symbols()
+	if len(symbols) != len(want) {
+		t.Fatalf("Symbols %v, want %v", symbols, want)
+	}
+	for i := range want {
+		if symbols[i] != want[i] {
+			t.Errorf("Symbol %d = %v, want %v", i, symbols[i], want[i])
+		}
+	}
+
+	mem := NewMemory(16, binary.BigEndian)
+	if err := p.Load(mem); err != nil {
+		t.Fatal(err)
+	}
+	data := mem.Dump()
+	if string(data[:7]) != "hi, #1\x00" {
+		t.Errorf("msg = %q", data[:7])
+	}
+	if data[8] != 0x00 || data[9] != 0x01 || data[10] != 0xFF || data[11] != 0xFE || data[15] != 5 {
+		t.Errorf("Data % X", data)
+	}
+
+	// Data directives take no PC
+	if i, _ := p.Fetch(1); i.Opcode != ADDI || i.Op3 != "0" {
+		t.Errorf("PC 1 = %+v, want la of msg", *i)
+	}
+
+	if err := p.Load(NewMemory(8, binary.BigEndian)); err == nil {
+		t.Error("Expected data not to fit 8 bytes")
+	}
+}
+
+func TestAssembleDataDiagnostics(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{".word 1", `prog.s:1:1: .word is only valid in the .data segment`},
+		{".data\nadd 1 2 3", `prog.s:2:1: instruction "add" in the .data segment`},
+		{".data\nx .byte 1, 256", `prog.s:2:12: invalid .byte value "256"`},
+		{".data\ns .asciiz hi", `prog.s:2:11: invalid string hi`},
+		{".data\n.align 4", `prog.s:2:8: invalid .align value "4"`},
+		{".data\n.half", `prog.s:2:1: .half expects at least 1 operand, got 0`},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble("prog.s", tt.src)
+		var diags Diagnostics
+		if !errors.As(err, &diags) {
+			t.Errorf("%q: expected diagnostics, got %v", tt.src, err)
+			continue
+		}
+		if diags[0].Error() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.src, diags[0], tt.want)
+		}
+	}
+}
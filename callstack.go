@@ -0,0 +1,60 @@
+package main
+
+import "fmt"
+
+// Register holding the stack pointer, $sp
+const stackPointer = "R29"
+
+// The stack grows down from the end of data memory, with $sp pointing to the
+// last word in use. It starts at the last word, so the first push goes below
+// it.
+func stackTop(memSize int) int64 {
+	return int64(memSize - wordSize)
+}
+
+// Procedure called by jal or jalr. Its frame is what the callee pushes below
+// the stack pointer it was called with.
+type Frame struct {
+	Caller int    // PC of the call
+	Target int    // PC called
+	Name   string // Label of the target, if it has one
+	Return int    // Return address linked in $ra
+	SP     int    // Address in $sp when called
+}
+
+func (f Frame) String() string {
+	name := f.Name
+	if name == "" {
+		name = fmt.Sprintf("PC %d", f.Target)
+	}
+	return fmt.Sprintf("%s called from PC %d, returns to %d", name, f.Caller, f.Return)
+}
+
+// Calls not returned yet, rebuilt from the jumps as they are written back,
+// when the registers hold their committed values
+type CallStack struct {
+	frames []Frame
+}
+
+func (c *CallStack) Call(f Frame) {
+	c.frames = append(c.frames, f)
+}
+
+// A jump to the return address of a frame returns from it, and from any
+// frame called after it. Other jumps are not returns.
+func (c *CallStack) Return(pc int) bool {
+	for n := len(c.frames) - 1; n >= 0; n-- {
+		if c.frames[n].Return == pc {
+			c.frames = c.frames[:n]
+			return true
+		}
+	}
+	return false
+}
+
+// Copy of the frames, the outermost first, safe to be used by the TUI
+func (c *CallStack) Frames() []Frame {
+	frames := make([]Frame, len(c.frames))
+	copy(frames, c.frames)
+	return frames
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCallStackReturn(t *testing.T) {
+	var c CallStack
+	c.Call(Frame{Caller: 2, Target: 10, Return: 3})
+	c.Call(Frame{Caller: 12, Target: 20, Return: 13})
+	c.Call(Frame{Caller: 22, Target: 30, Return: 23})
+
+	if c.Return(5) {
+		t.Error("Jump to PC 5 returned from a frame")
+	}
+	if len(c.Frames()) != 3 {
+		t.Fatalf("Frames = %d, want 3", len(c.Frames()))
+	}
+
+	// Returning from the middle frame drops the one it called
+	if !c.Return(13) {
+		t.Error("Jump to PC 13 did not return")
+	}
+	if frames := c.Frames(); len(frames) != 1 || frames[0].Target != 10 {
+		t.Errorf("Frames = %v, want the call to 10 only", frames)
+	}
+}
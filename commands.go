@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Subcommands converting between assembly, machine code and memory images,
+// without running the pipeline
+var commands = map[string]func(args []string) error{
+	"asm":    runAsm,
+	"disasm": runDisasm,
+	"export": runExport,
+}
+
+func commandFlags(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.IntVar(&registerWidth, "width", registerWidth, fmt.Sprintf("register and memory word width in `bits`, one of %v", registerWidths))
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] %s\n\nOptions:\n", os.Args[0], name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parses the arguments, which must leave only the program
+func parseCommand(flags *flag.FlagSet, args []string) string {
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if _, err := NewRegisterFile(numRegisters, registerWidth); err != nil {
+		fail(exitUsage, err)
+	}
+	wordSize = registerWidth / 8
+	return flags.Arg(0)
+}
+
+// Output of a command, the file or the standard output if there is none
+func writeOutput(file string, write func(io.Writer) error) error {
+	if file == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the machine code of the text segment, as hex text or raw words
+func runAsm(args []string) error {
+	flags := commandFlags("asm", "program")
+	out := flags.String("o", "", "write the words to `file`, raw if it ends in .bin, instead of the standard output")
+	filename := parseCommand(flags, args)
+
+	program, err := ReadProgram(filename)
+	if err != nil {
+		return err
+	}
+	if err := program.CheckEncoding(); err != nil {
+		return err
+	}
+	return writeOutput(*out, func(w io.Writer) error {
+		return WriteWords(w, program.Words(), filepath.Ext(*out))
+	})
+}
+
+// Writes the memory image of a segment, for the ROM or RAM of a datapath
+func runExport(args []string) error {
+	flags := commandFlags("export", "program")
+	out := flags.String("o", "", "write the image to `file` instead of the standard output")
+	format := flags.String("format", intelHex, fmt.Sprintf("image `format`, one of %s", strings.Join(exportFormats, ", ")))
+	segment := flags.String("segment", textSegment, fmt.Sprintf("`segment` to export, %s or %s", textSegment, dataSegment))
+	flags.StringVar(&endianness, "endian", endianness, "byte `order` of the data segment words, big or little")
+	filename := parseCommand(flags, args)
+	order, err := ParseByteOrder(endianness)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	memoryOrder = order
+	if !slices.Contains(exportFormats, *format) {
+		fail(exitUsage, fmt.Errorf("Unknown format %s, expected one of %v", *format, exportFormats))
+	}
+	if *segment != textSegment && *segment != dataSegment {
+		fail(exitUsage, fmt.Errorf("Unknown segment %s, expected %s or %s", *segment, textSegment, dataSegment))
+	}
+
+	program, err := ReadProgram(filename)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, func(w io.Writer) error {
+		return program.Export(w, *segment, *format)
+	})
+}
+
+// Prints the assembly source of a .hex or .bin program
+func runDisasm(args []string) error {
+	filename := parseCommand(commandFlags("disasm", "program.hex"), args)
+	if !isMachineCode(filename) {
+		return fmt.Errorf("%s is not machine code, expected a %s or %s file", filename, hexExt, binExt)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	words, err := ReadWords(filename, b)
+	if err != nil {
+		return err
+	}
+	fmt.Print(Disassemble(words))
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Segments. Instructions go to .text, the default, where each one takes a
+// PC. Data directives go to .data, laid out in data memory from address 0
+const (
+	textSegment = ".text"
+	dataSegment = ".data"
+)
+
+// Data directives and the bytes each of their values takes. .word and .fill
+// take a memory word, as wide as the registers
+var dataDirectives = map[string]int{
+	FILL:      0,
+	".word":   0,
+	".half":   2,
+	".byte":   1,
+	".space":  1,
+	".ascii":  1,
+	".asciiz": 1,
+	".align":  0,
+}
+
+func isDataDirective(s string) bool {
+	_, ok := dataDirectives[s]
+	return ok
+}
+
+// Values stored from Addr on, each taking Size bytes in the memory byte order
+type DataItem struct {
+	Pos    Position
+	Addr   int
+	Size   int
+	Values []int
+}
+
+// Next address multiple of n
+func align(addr, n int) int {
+	return (addr + n - 1) / n * n
+}
+
+// Values that fit size bytes, either signed or unsigned
+func fitsBytes(v int, size int) bool {
+	bits := 8 * size
+	return v >= -1<<(bits-1) && v < 1<<bits
+}
+
+// Lays out a data directive at address. Returns where its data starts, after
+// the alignment, the next free address and the values to be stored, if any
+func (p *Program) layout(directive Token, operands []Token, address int, report func(Position, string, ...any)) (int, int, *DataItem) {
+	name := directive.Text
+	want := 1
+	switch name {
+	case ".word", ".half", ".byte":
+		want = len(operands)
+		if want == 0 {
+			report(directive.Pos, "%s expects at least 1 operand, got 0", name)
+			return address, address, nil
+		}
+	}
+	if len(operands) != want {
+		report(directive.Pos, "%s expects %d operands, got %d", name, want, len(operands))
+		return address, address, nil
+	}
+
+	switch name {
+	case ".ascii", ".asciiz":
+		s, err := strconv.Unquote(operands[0].Text)
+		if err != nil || operands[0].Text[0] != '"' {
+			report(operands[0].Pos, "invalid string %s", operands[0].Text)
+			return address, address, nil
+		}
+		if name == ".asciiz" {
+			s += "\x00"
+		}
+		item := &DataItem{Pos: directive.Pos, Addr: address, Size: 1}
+		for _, b := range []byte(s) {
+			item.Values = append(item.Values, int(b))
+		}
+		return address, address + len(s), item
+
+	case ".space", ".align":
+		n, err := strconv.Atoi(operands[0].Text)
+		if err != nil || n < 0 || (name == ".align" && n > 3) {
+			report(operands[0].Pos, "invalid %s value %q", name, operands[0].Text)
+			return address, address, nil
+		}
+		if name == ".align" {
+			address = align(address, 1<<n)
+			return address, address, nil
+		}
+		return address, address + n, nil
+	}
+
+	size := dataDirectives[name]
+	if size == 0 {
+		size = wordSize
+	}
+	start := align(address, size)
+	item := &DataItem{Pos: directive.Pos, Addr: start, Size: size}
+	for _, op := range operands {
+		v, err := strconv.ParseInt(op.Text, 0, 64)
+		if err != nil || !fitsBytes(int(v), size) {
+			report(op.Pos, "invalid %s value %q", name, op.Text)
+			continue
+		}
+		item.Values = append(item.Values, int(v))
+	}
+	return start, start + len(operands)*size, item
+}
+
+// Label of the program, pointing to a PC in .text or an address in .data
+type Symbol struct {
+	Name    string
+	Segment string
+	Value   int
+}
+
+// Symbol table, the text labels first, each segment in order. Labels of
+// .fill out of the .data segment are data
+func (p *Program) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(p.Labels)+len(p.Data))
+	for name, pc := range p.Labels {
+		if _, ok := p.Data[name]; !ok {
+			symbols = append(symbols, Symbol{Name: name, Segment: textSegment, Value: pc})
+		}
+	}
+	for name, addr := range p.Data {
+		symbols = append(symbols, Symbol{Name: name, Segment: dataSegment, Value: addr})
+	}
+
+	sort.Slice(symbols, func(a, b int) bool {
+		if symbols[a].Segment != symbols[b].Segment {
+			return symbols[a].Segment == textSegment
+		}
+		if symbols[a].Value != symbols[b].Value {
+			return symbols[a].Value < symbols[b].Value
+		}
+		return symbols[a].Name < symbols[b].Name
+	})
+	return symbols
+}
+
+func (s Symbol) String() string {
+	if s.Segment == textSegment {
+		return fmt.Sprintf("%s PC %d", s.Name, s.Value)
+	}
+	return fmt.Sprintf("%s %04X", s.Name, s.Value)
+}
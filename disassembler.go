@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Extensions of the machine code files. .hex has a word per line, in
+// hexadecimal or as 32 binary digits, as the MARS "Hexadecimal Text" and
+// "Binary Text" dumps, or Intel HEX records. .bin has the raw words, big
+// endian
+const (
+	hexExt = ".hex"
+	binExt = ".bin"
+)
+
+// Word address where MARS places the text segment, 0x00400000. Jumps to it
+// are jumps to PC 1
+const marsTextBase = 0x00400000 >> 2
+
+func isMachineCode(filename string) bool {
+	ext := filepath.Ext(filename)
+	return ext == hexExt || ext == binExt
+}
+
+// Program in filename, either assembly or machine code by the extension.
+// Machine code is disassembled and assembled again, so both get the same
+// diagnostics
+func ReadProgram(filename string) (*Program, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isMachineCode(filename) {
+		return Assemble(filename, string(b))
+	}
+
+	words, err := ReadWords(filename, b)
+	if err != nil {
+		return nil, err
+	}
+	return Assemble(filename, Disassemble(words))
+}
+
+// Machine code words of a .hex or .bin file
+func ReadWords(filename string, b []byte) ([]uint32, error) {
+	words := make([]uint32, 0)
+	if filepath.Ext(filename) == binExt {
+		if len(b)%4 != 0 {
+			return nil, fmt.Errorf("%s has %d bytes, not a multiple of 4", filename, len(b))
+		}
+		for n := 0; n < len(b); n += 4 {
+			words = append(words, binary.BigEndian.Uint32(b[n:]))
+		}
+		return words, nil
+	}
+
+	var diags Diagnostics
+	// Intel HEX records, as export writes, and their bytes
+	records, image, base := 0, make(map[int]byte), 0
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for num := 1; scanner.Scan(); num++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, ":") {
+			var err error
+			if base, err = readIntelHexRecord(text, base, image); err != nil {
+				diags = append(diags, Diagnostic{File: filename, Pos: Position{num, 1}, Message: err.Error()})
+			}
+			records++
+			continue
+		}
+		base := 16
+		if len(text) == 32 && strings.Trim(text, "01") == "" {
+			base = 2
+		}
+		w, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X"), base, 32)
+		if err != nil {
+			diags = append(diags, Diagnostic{File: filename, Pos: Position{num, 1}, Message: fmt.Sprintf("invalid word %q", text)})
+			continue
+		}
+		words = append(words, uint32(w))
+	}
+	if records > 0 && len(words) > 0 {
+		diags = append(diags, Diagnostic{File: filename, Pos: Position{1, 1}, Message: "mixes Intel HEX records and words"})
+	}
+	if len(diags) > 0 {
+		return nil, diags
+	}
+	if records > 0 {
+		return intelHexWords(image), nil
+	}
+	return words, nil
+}
+
+// Writes the words to a .hex file, or raw to a .bin one
+func WriteWords(out io.Writer, words []uint32, ext string) error {
+	if ext == binExt {
+		return binary.Write(out, binary.BigEndian, words)
+	}
+	for _, w := range words {
+		if _, err := fmt.Fprintf(out, "%08x\n", w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Instructions without machine code, whose words would not do what the
+// simulator runs
+func (p *Program) CheckEncoding() error {
+	var diags Diagnostics
+	for _, s := range p.Statements {
+		if s.encodeErr != nil {
+			diags = append(diags, Diagnostic{File: p.File, Pos: s.Pos, Message: s.encodeErr.Error()})
+		}
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	return nil
+}
+
+// Machine code of the text segment, a word per PC. .fill takes its value.
+// See CheckEncoding for the instructions that have none
+func (p *Program) Words() []uint32 {
+	words := make([]uint32, 0, len(p.Statements))
+	for _, s := range p.Statements {
+		if s.Instruction.Opcode == Opcode(FILL) {
+			v, _ := strconv.ParseInt(s.Instruction.Op1, 0, 64)
+			words = append(words, uint32(v))
+			continue
+		}
+		words = append(words, s.Instruction.Encoding.Word)
+	}
+	return words
+}
+
+// Assembly source of the words, line n with PC n. Branch and jump targets
+// are labeled L<pc>. Words that are not an instruction of the simulator
+// become .fill, with the reason as a comment, and so do branches out of the
+// program. .fill needs a label too
+func Disassemble(words []uint32) string {
+	lines := make([]string, len(words))
+	labeled := make(map[int]bool)
+	for n, w := range words {
+		pc := n + 1
+		text, target, err := disassembleWord(w, pc)
+		if err == nil && target > len(words) {
+			err = errOutOfProgram(target)
+		}
+		if err != nil {
+			lines[n] = fmt.Sprintf("%s %d # %s", FILL, int32(w), err)
+			labeled[pc] = true
+			continue
+		}
+		if target != 0 {
+			labeled[target] = true
+		}
+		lines[n] = text
+	}
+	for pc := range labeled {
+		lines[pc-1] = targetLabel(pc) + " " + lines[pc-1]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func errOutOfProgram(target int) error {
+	return fmt.Errorf("target PC %d is out of the program", target)
+}
+
+func targetLabel(pc int) string {
+	return "L" + strconv.Itoa(pc)
+}
+
+func reg(n uint32) string {
+	return "R" + strconv.Itoa(int(n))
+}
+
+// Opcode with the value in fields, other than skip
+func opcodeFor(fields map[Opcode]uint32, v uint32, skip Opcode) (Opcode, bool) {
+	for op, field := range fields {
+		if field == v && op != skip {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// Source of a machine code word at pc, the inverse of Encode. Returns the
+// target PC of branches and jumps, 0 for the other instructions
+func disassembleWord(word uint32, pc int) (string, int, error) {
+	opcode := word >> 26
+	rs, rt, rd := word>>21&31, word>>16&31, word>>11&31
+	shamt, funct := word>>6&31, word&63
+	imm := int16(word)
+
+	if opcode == 0 {
+		if word == 0 {
+			return string(NOOP), 0, nil
+		}
+		op, ok := opcodeFor(functFields, funct, NOOP)
+		if !ok {
+			return "", 0, fmt.Errorf("unknown funct 0x%02X", funct)
+		}
+		switch op {
+		case SLLV, SRLV, SRAV:
+			return fmt.Sprintf("%s %s %s %s", op, reg(rd), reg(rt), reg(rs)), 0, nil
+		case SLL, SRL, SRA:
+			return fmt.Sprintf("%s %s %s %d", op, reg(rd), reg(rt), shamt), 0, nil
+		case MULT, MULTU, DIV, DIVU:
+			return fmt.Sprintf("%s %s %s", op, reg(rs), reg(rt)), 0, nil
+		case MFHI, MFLO:
+			return fmt.Sprintf("%s %s", op, reg(rd)), 0, nil
+		case JALR:
+			// The simulator always links $ra
+			if rd != 31 {
+				return "", 0, fmt.Errorf("jalr links %s, only R31 is supported", reg(rd))
+			}
+			fallthrough
+		case JR:
+			return fmt.Sprintf("%s %s", op, reg(rs)), 0, nil
+		case HALT:
+			return string(HALT), 0, nil
+		}
+		return fmt.Sprintf("%s %s %s %s", op, reg(rd), reg(rs), reg(rt)), 0, nil
+	}
+
+	// Branches and jumps, labeling a target in the program
+	target := pc + 1 + int(imm)
+	branch := func(format string, args ...any) (string, int, error) {
+		if target < 1 {
+			return "", 0, errOutOfProgram(target)
+		}
+		return fmt.Sprintf(format, append(args, targetLabel(target))...), target, nil
+	}
+
+	if opcode == opcodeFields[BLTZ] {
+		switch rt {
+		case 0:
+			return branch("%s %s %s", BLTZ, reg(rs))
+		case 1:
+			return branch("%s %s %s", BGEZ, reg(rs))
+		}
+		return "", 0, fmt.Errorf("unknown REGIMM rt %d", rt)
+	}
+
+	op, ok := opcodeFor(opcodeFields, opcode, SUBI)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown opcode 0x%02X", opcode)
+	}
+	switch op {
+	case ADDI, SLTI, SLTIU:
+		return fmt.Sprintf("%s %s %s %d", op, reg(rt), reg(rs), imm), 0, nil
+	case ANDI, ORI, XORI:
+		return fmt.Sprintf("%s %s %s 0x%X", op, reg(rt), reg(rs), uint16(imm)), 0, nil
+	case LUI:
+		return fmt.Sprintf("%s %s 0x%X", op, reg(rt), uint16(imm)), 0, nil
+	case LW, SW:
+		return fmt.Sprintf("%s %s %d(%s)", op, reg(rt), imm, reg(rs)), 0, nil
+	case BEQ, BNE:
+		return branch("%s %s %s %s", op, reg(rs), reg(rt))
+	case BLEZ, BGTZ:
+		return branch("%s %s %s", op, reg(rs))
+	}
+
+	// J and JAL, with the address of MARS dumps or a PC
+	target = int(word & 0x3FFFFFF)
+	if target >= marsTextBase {
+		target -= marsTextBase - 1
+	}
+	return branch("%s %s", op)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	setWidth(t, 32)
+	sources := []string{
+		strings.Join(factorialProgram, "\n"),
+		"add R1 R2 R3\nsllv R1 R2 R3\nsra R4 R5 31\nandi R1 R2 0xFFFF\nlui R1 0x1234\nslti R1 R2 -7\n" +
+			"loop bltz R1 loop\nbgez R1 end\nbgtz R2 loop\nmultu R1 R2\nmfhi R3\ndivu R1 R2\nmflo R4\n" +
+			"sw R1 -8($sp)\njalr R9\nxor R1 R2 R3\nj loop\nend halt\n",
+	}
+
+	for _, src := range sources {
+		p, err := Assemble("prog.s", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		words := p.Words()
+
+		disasm := Disassemble(words)
+		q, err := Assemble("prog.hex", disasm)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, disasm)
+		}
+		for n, w := range q.Words() {
+			if w != words[n] {
+				t.Errorf("PC %d: 0x%08X, want 0x%08X", n+1, w, words[n])
+			}
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	words := []uint32{
+		0x00430820, // add R1 R2 R3
+		0x1022FFFF, // beq R1 R2 to itself
+		0x08100000, // j to the MARS text base
+		0xFC000000, // Unknown opcode
+		0x1000FFF0, // beq out of the program
+		0x0000000C,
+	}
+
+	want := []string{
+		"L1 add R1 R2 R3",
+		"L2 beq R1 R2 L2",
+		"j L1",
+		"L4 .fill -67108864 # unknown opcode 0x3F",
+		"L5 .fill 268500976 # target PC -10 is out of the program",
+		"halt",
+	}
+	got := strings.Split(strings.TrimSuffix(Disassemble(words), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("Got %q, want %q", got, want)
+	}
+	for n := range want {
+		if got[n] != want[n] {
+			t.Errorf("PC %d = %q, want %q", n+1, got[n], want[n])
+		}
+	}
+}
+
+func TestReadWords(t *testing.T) {
+	hex := "20010005\n\n0x00430820 # add\n00000000001000100001100000100000\n"
+	words, err := ReadWords("prog.hex", []byte(hex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{0x20010005, 0x00430820, 0x00221820}
+	if len(words) != len(want) {
+		t.Fatalf("Got %v, want %v", words, want)
+	}
+	for n := range want {
+		if words[n] != want[n] {
+			t.Errorf("Word %d = 0x%08X, want 0x%08X", n, words[n], want[n])
+		}
+	}
+
+	var raw bytes.Buffer
+	if err := WriteWords(&raw, want, binExt); err != nil {
+		t.Fatal(err)
+	}
+	if words, err := ReadWords("prog.bin", raw.Bytes()); err != nil || len(words) != 3 || words[2] != want[2] {
+		t.Errorf("Raw words %v, %v, want %v", words, err, want)
+	}
+
+	_, err = ReadWords("prog.hex", []byte("20010005\nadd R1\n"))
+	if err == nil || !strings.Contains(err.Error(), "prog.hex:2:1: invalid word") {
+		t.Errorf("Got error %v, want invalid word at line 2", err)
+	}
+	if _, err := ReadWords("prog.bin", []byte{1, 2, 3}); err == nil {
+		t.Error("Got no error for 3 bytes")
+	}
+}
+
+func TestTickMachineCode(t *testing.T) {
+	// addi R0 R1 3, loop: subi R1 R1 1, addi R2 R2 1, bne R1 R0 loop, halt
+	filename := filepath.Join(t.TempDir(), "program.hex")
+	if err := os.WriteFile(filename, []byte("20010003\n2021ffff\n20420001\n1420fffd\n0000000c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	predictor, err := NewPredictor("not-taken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs, err := NewRegisterFile(numRegisters, registerWidth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline(filename, NewMemory(64, binary.BigEndian), regs, fullForwarding, predictor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runUntilHalt(t, p, 100)
+
+	if r2, _ := p.Regs.Get("R2"); r2 != 3 {
+		t.Errorf("Loop ran %d times, want 3", r2)
+	}
+}
+
+func TestTickAssembledProgram(t *testing.T) {
+	program := []string{
+		"lw R1 neg1(R0)",
+		"lw R2 ten(R0)",
+		"loop add R3 R3 R2",
+		"add R2 R2 R1",
+		"bne R2 R0 loop",
+		"sw R3 sum(R0)",
+		"done halt",
+		"neg1 .fill -1",
+		"ten .fill 10",
+		"sum .fill 1",
+	}
+	source := newTestPipeline(t, program, fullForwarding, "not-taken")
+	runUntilHalt(t, source, 200)
+	if err := source.Program.CheckEncoding(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The words asm writes, run as machine code
+	var hex bytes.Buffer
+	if err := WriteWords(&hex, source.Program.Words(), hexExt); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "program.hex")
+	if err := os.WriteFile(filename, hex.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	machine := loadTestPipeline(t, filename, fullForwarding, "not-taken")
+	runUntilHalt(t, machine, 200)
+
+	if r3, _ := source.Regs.Get("R3"); r3 != 55 {
+		t.Errorf("R3 = %d, want 55", r3)
+	}
+	for n := 0; n < source.Regs.Count(); n++ {
+		name := source.Regs.Name(n)
+		want, _ := source.Regs.Get(name)
+		if got, _ := machine.Regs.Get(name); got != want {
+			t.Errorf("%s = %d from machine code, want %d", name, got, want)
+		}
+	}
+	if !bytes.Equal(machine.Mem.Dump(), source.Mem.Dump()) {
+		t.Errorf("Memory from machine code % X, want % X", machine.Mem.Dump(), source.Mem.Dump())
+	}
+
+	// addi with a data label has no machine code to run
+	if p := newTestPipeline(t, loopProgram, fullForwarding, "not-taken"); p.Program.CheckEncoding() == nil {
+		t.Error("loopProgram assembled, but addi with a data label has no encoding")
+	}
+}
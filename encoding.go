@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Formats of the 32-bit MIPS instruction words
+type InstructionFormat byte
+
+const (
+	RType InstructionFormat = 'R'
+	IType InstructionFormat = 'I'
+	JType InstructionFormat = 'J'
+)
+
+// Machine code of an instruction. The zero value is an instruction without
+// encoding, as .fill
+type Encoding struct {
+	Format InstructionFormat
+	Word   uint32
+}
+
+// Field of an encoded word
+type Field struct {
+	Name  string
+	Bits  int
+	Value uint32
+}
+
+type fieldLayout struct {
+	name string
+	bits int
+}
+
+// Fields of each format, from the most significant bits
+var formatFields = map[InstructionFormat][]fieldLayout{
+	RType: {{"opcode", 6}, {"rs", 5}, {"rt", 5}, {"rd", 5}, {"shamt", 5}, {"funct", 6}},
+	IType: {{"opcode", 6}, {"rs", 5}, {"rt", 5}, {"immediate", 16}},
+	JType: {{"opcode", 6}, {"address", 26}},
+}
+
+// Opcode field of the I and J-type instructions. bltz and bgez share the
+// REGIMM opcode, told apart by rt
+var opcodeFields = map[Opcode]uint32{
+	ADDI:  0x08,
+	SUBI:  0x08, // addi with the immediate negated
+	SLTI:  0x0A,
+	SLTIU: 0x0B,
+	ANDI:  0x0C,
+	ORI:   0x0D,
+	XORI:  0x0E,
+	LUI:   0x0F,
+	LW:    0x23,
+	SW:    0x2B,
+	BEQ:   0x04,
+	BNE:   0x05,
+	BLEZ:  0x06,
+	BGTZ:  0x07,
+	BLTZ:  0x01,
+	BGEZ:  0x01,
+	J:     0x02,
+	JAL:   0x03,
+}
+
+// Funct field of the R-type instructions, whose opcode field is 0. halt is
+// encoded as syscall, as MARS programs exit with one
+var functFields = map[Opcode]uint32{
+	SLL:   0x00,
+	SRL:   0x02,
+	SRA:   0x03,
+	SLLV:  0x04,
+	SRLV:  0x06,
+	SRAV:  0x07,
+	JR:    0x08,
+	JALR:  0x09,
+	HALT:  0x0C,
+	MFHI:  0x10,
+	MFLO:  0x12,
+	MULT:  0x18,
+	MULTU: 0x19,
+	DIV:   0x1A,
+	DIVU:  0x1B,
+	ADD:   0x20,
+	SUB:   0x22,
+	AND:   0x24,
+	OR:    0x25,
+	XOR:   0x26,
+	NOR:   0x27,
+	SLT:   0x2A,
+	SLTU:  0x2B,
+	NOOP:  0x00, // sll R0 R0 0
+}
+
+func rType(rs, rt, rd, shamt, funct uint32) Encoding {
+	return Encoding{RType, rs<<21 | rt<<16 | rd<<11 | shamt<<6 | funct}
+}
+
+func iType(op, rs, rt uint32, imm int64) Encoding {
+	return Encoding{IType, op<<26 | rs<<21 | rt<<16 | uint32(imm)&0xFFFF}
+}
+
+func jType(op uint32, addr int) Encoding {
+	return Encoding{JType, op<<26 | uint32(addr)&0x3FFFFFF}
+}
+
+// Register number in the 5 bits of the rs, rt and rd fields
+func registerField(name string) (uint32, error) {
+	n, ok := registerNumber(name)
+	if !ok || n > 31 {
+		return 0, fmt.Errorf("Register %s has no encoding", name)
+	}
+	return uint32(n), nil
+}
+
+// Registers of the operands, in order
+func registerFields(names ...string) ([]uint32, error) {
+	fields := make([]uint32, len(names))
+	for n, name := range names {
+		f, err := registerField(name)
+		if err != nil {
+			return nil, err
+		}
+		fields[n] = f
+	}
+	return fields, nil
+}
+
+// Machine code of an assembled instruction. data has the addresses of the
+// data labels used as operands. Branch offsets count instructions from the
+// next one, and jump addresses are the target PC.
+//
+// addi and subi with a data label load the word at the label, which MIPS can
+// not do in one instruction, so they have no encoding. With a register they
+// are encoded as add and sub.
+func Encode(i *Instruction, data map[string]int) (Encoding, error) {
+	op := i.Opcode
+	switch op {
+	case ADD, SUB, AND, OR, XOR, NOR, SLT, SLTU:
+		r, err := registerFields(i.Op2, i.Op3, i.Op1)
+		if err != nil {
+			return Encoding{}, err
+		}
+		return rType(r[0], r[1], r[2], 0, functFields[op]), nil
+
+	case SLLV, SRLV, SRAV:
+		r, err := registerFields(i.Op3, i.Op2, i.Op1)
+		if err != nil {
+			return Encoding{}, err
+		}
+		return rType(r[0], r[1], r[2], 0, functFields[op]), nil
+
+	case SLL, SRL, SRA:
+		r, err := registerFields(i.Op2, i.Op1)
+		if err != nil {
+			return Encoding{}, err
+		}
+		return rType(0, r[0], r[1], uint32(i.Imm), functFields[op]), nil
+
+	case MULT, MULTU, DIV, DIVU:
+		r, err := registerFields(i.Op1, i.Op2)
+		if err != nil {
+			return Encoding{}, err
+		}
+		return rType(r[0], r[1], 0, 0, functFields[op]), nil
+
+	case MFHI, MFLO:
+		rd, err := registerField(i.Op1)
+		return rType(0, 0, rd, 0, functFields[op]), err
+
+	case JR:
+		rs, err := registerField(i.Op1)
+		return rType(rs, 0, 0, 0, functFields[op]), err
+
+	case JALR:
+		rs, err := registerField(i.Op1)
+		return rType(rs, 0, 31, 0, functFields[op]), err
+
+	case HALT, NOOP:
+		return rType(0, 0, 0, 0, functFields[op]), nil
+
+	case ADDI, SUBI, SLTI, SLTIU, ANDI, ORI, XORI:
+		dest, src := iOperands(i)
+		r, err := registerFields(src, dest)
+		if err != nil {
+			return Encoding{}, err
+		}
+		if !i.Immediate {
+			if _, ok := data[i.Op3]; ok {
+				return Encoding{}, fmt.Errorf("%s with data label %s loads the word at the label and has no encoding, use lw", op, i.Op3)
+			}
+			rt, err := registerField(i.Op3)
+			funct := functFields[ADD]
+			if op == SUBI {
+				funct = functFields[SUB]
+			}
+			return rType(r[0], rt, r[1], 0, funct), err
+		}
+		imm := i.Imm
+		if op == SUBI {
+			imm = -imm
+		}
+		return iType(opcodeFields[op], r[0], r[1], imm), nil
+
+	case LUI:
+		rt, err := registerField(i.Op1)
+		return iType(opcodeFields[op], 0, rt, i.Imm), err
+
+	case LW, SW:
+		rt, err := registerField(i.Op1)
+		if err != nil {
+			return Encoding{}, err
+		}
+		offset, base, hasBase := strings.Cut(i.Op2, "(")
+		var rs uint32
+		if hasBase {
+			if rs, err = registerField(strings.TrimSuffix(base, ")")); err != nil {
+				return Encoding{}, err
+			}
+		}
+		imm, ok := data[offset]
+		if !ok && offset != "" {
+			if imm, err = strconv.Atoi(offset); err != nil {
+				return Encoding{}, fmt.Errorf("Invalid offset %s", offset)
+			}
+		}
+		return iType(opcodeFields[op], rs, rt, int64(imm)), nil
+
+	case BEQ, BNE:
+		r, err := registerFields(i.Op1, i.Op2)
+		if err != nil {
+			return Encoding{}, err
+		}
+		return iType(opcodeFields[op], r[0], r[1], int64(i.Target-i.PC-1)), nil
+
+	case BLEZ, BGTZ, BLTZ, BGEZ:
+		rs, err := registerField(i.Op1)
+		var rt uint32
+		if op == BGEZ {
+			rt = 1
+		}
+		return iType(opcodeFields[op], rs, rt, int64(i.Target-i.PC-1)), err
+
+	case J, JAL:
+		return jType(opcodeFields[op], i.Target), nil
+	}
+	return Encoding{}, fmt.Errorf("%s has no encoding", op)
+}
+
+func (e Encoding) Fields() []Field {
+	fields := make([]Field, 0)
+	shift := 32
+	for _, f := range formatFields[e.Format] {
+		shift -= f.bits
+		fields = append(fields, Field{Name: f.name, Bits: f.bits, Value: e.Word >> shift & (1<<f.bits - 1)})
+	}
+	return fields
+}
+
+// Word in hexadecimal, as 0x00430820
+func (e Encoding) String() string {
+	return fmt.Sprintf("0x%08X", e.Word)
+}
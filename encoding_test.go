@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestEncode(t *testing.T) {
+	src := "add R1 R2 R3\naddi R1 R2 5\nsubi R1 R2 5\nlw R8 4($sp)\nsll R1 R2 4\n" +
+		"loop beq R1 R2 loop\nj loop\njr $ra\nbgez R1 done\ndone halt\n" +
+		"addi R0 R1 R2\nlw R3 one(R0)\nmult R1 R2\nnop\n" +
+		"zero .fill 0\none .fill 1\n"
+
+	p, err := Assemble("prog.s", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint32{
+		0x00430820, // R-type, funct 0x20
+		0x20410005, // rt R1, rs R2
+		0x2041FFFB, // addi with -5
+		0x8FA80004,
+		0x00020900, // shamt 4
+		0x1022FFFF, // Branch to itself, -1 from the next one
+		0x08000006,
+		0x03E00008,
+		0x04210000, // REGIMM, rt 1
+		0x0000000C, // syscall
+		0x00020820, // add with a register operand
+		0x8C030001,
+		0x00220018,
+		0x00000000,
+	}
+	for n, w := range want {
+		i, _ := p.Fetch(n + 1)
+		if i.Encoding.Word != w {
+			t.Errorf("%v = %s, want 0x%08X", i, i.Encoding, w)
+		}
+	}
+	if i, _ := p.Fetch(len(want) + 1); i.Encoding.Format != 0 {
+		t.Errorf(".fill encoded as %s", i.Encoding)
+	}
+}
+
+func TestEncodeDataLabel(t *testing.T) {
+	p, err := Assemble("prog.s", "nop\nloop addi R0 R1 one\nsubi R0 R2 one\none .fill 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Runs, loading the word at one, but has no machine code
+	want := "prog.s:2:1: addi with data label one loads the word at the label and has no encoding, use lw\n" +
+		"prog.s:3:1: subi with data label one loads the word at the label and has no encoding, use lw"
+	if err := p.CheckEncoding(); err == nil || err.Error() != want {
+		t.Errorf("Got %v, want %s", err, want)
+	}
+	if i, _ := p.Fetch(2); i.Encoding.Format != 0 {
+		t.Errorf("%v encoded as %s", i, i.Encoding)
+	}
+}
+
+func TestEncodingFields(t *testing.T) {
+	e := Encoding{RType, 0x00430820}
+
+	want := []uint32{0, 2, 3, 1, 0, 0x20}
+	fields := e.Fields()
+	if len(fields) != len(want) {
+		t.Fatalf("Got %d fields, want %d", len(fields), len(want))
+	}
+	for n, f := range fields {
+		if f.Value != want[n] {
+			t.Errorf("%s = %d, want %d", f.Name, f.Value, want[n])
+		}
+	}
+
+	if got := (Encoding{JType, 0x08000006}).Fields()[1]; got.Name != "address" || got.Value != 6 {
+		t.Errorf("J-type address = %s %d, want 6", got.Name, got.Value)
+	}
+}
@@ -13,9 +13,35 @@ type stageToggledMsg struct {
 	value    any
 }
 
+type tickedMsg struct {
+	cycles int
+}
+
+type stallMsg struct {
+	position int
+}
+
+type predictionMsg struct {
+	stats BranchStats
+}
+
 type registerUpdatedMsg struct {
 	name  string
-	value int8
+	value int64
+}
+
+type memoryUpdatedMsg struct {
+	address int
+	value   []byte
+}
+
+type callStackMsg struct {
+	frames []Frame
+}
+
+type timelineMsg struct {
+	cycle int
+	cells []TimelineCell
 }
 
 type debugMsg struct {
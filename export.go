@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Memory image formats, for the ROM and RAM of a datapath built in hardware
+const (
+	intelHex = "ihex"
+	logisim  = "logisim"
+)
+
+var exportFormats = []string{intelHex, logisim}
+
+// Bytes of Intel HEX data records
+const intelHexRecordSize = 16
+
+// Intel HEX record types
+const (
+	intelHexData          = 0x00
+	intelHexEOF           = 0x01
+	intelHexLinearAddress = 0x04
+)
+
+// Bytes of the data segment, as Load stores them in memory
+func (p *Program) DataImage() ([]byte, error) {
+	mem := NewMemory(p.DataSize, memoryOrder)
+	if err := p.Load(mem); err != nil {
+		return nil, err
+	}
+	return mem.Dump(), nil
+}
+
+// Bytes of the text segment, each word big endian from address 0, as MIPS
+// fetches them
+func (p *Program) TextImage() []byte {
+	words := p.Words()
+	b := make([]byte, 4*len(words))
+	for n, w := range words {
+		binary.BigEndian.PutUint32(b[4*n:], w)
+	}
+	return b
+}
+
+func intelHexRecord(typ byte, addr uint16, data []byte) string {
+	record := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}, data...)
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	return fmt.Sprintf(":%X%02X\n", record, -sum)
+}
+
+// Intel HEX image of b from address 0. Addresses past 64 KiB take extended
+// linear address records
+func WriteIntelHex(out io.Writer, b []byte) error {
+	var sb strings.Builder
+	for addr := 0; addr < len(b); addr += intelHexRecordSize {
+		if addr > 0 && addr&0xFFFF == 0 {
+			sb.WriteString(intelHexRecord(intelHexLinearAddress, 0, []byte{byte(addr >> 24), byte(addr >> 16)}))
+		}
+		end := min(addr+intelHexRecordSize, len(b))
+		sb.WriteString(intelHexRecord(intelHexData, uint16(addr), b[addr:end]))
+	}
+	sb.WriteString(intelHexRecord(intelHexEOF, 0, nil))
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+// Bytes of an Intel HEX record line, at their address
+func readIntelHexRecord(line string, base int, image map[int]byte) (int, error) {
+	record, err := hex.DecodeString(strings.TrimPrefix(line, ":"))
+	if err != nil || len(record) < 5 || len(record) != 5+int(record[0]) {
+		return base, fmt.Errorf("invalid Intel HEX record %q", line)
+	}
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	if sum != 0 {
+		return base, fmt.Errorf("invalid Intel HEX checksum in %q", line)
+	}
+
+	data := record[4 : len(record)-1]
+	switch record[3] {
+	case intelHexData:
+		addr := base + int(record[1])<<8 + int(record[2])
+		for n, b := range data {
+			image[addr+n] = b
+		}
+	case intelHexLinearAddress:
+		if len(data) != 2 {
+			return base, fmt.Errorf("invalid Intel HEX record %q", line)
+		}
+		base = (int(data[0])<<8 + int(data[1])) << 16
+	}
+	return base, nil
+}
+
+// Words of an Intel HEX image, big endian from address 0
+func intelHexWords(image map[int]byte) []uint32 {
+	size := 0
+	for addr := range image {
+		size = max(size, addr+1)
+	}
+	words := make([]uint32, (size+3)/4)
+	for addr, b := range image {
+		words[addr/4] |= uint32(b) << (8 * (3 - addr%4))
+	}
+	return words
+}
+
+// Logisim "v2.0 raw" image, a cell per value in hexadecimal, 8 per line.
+// Runs of 4 or more equal cells are written as n*value, as Logisim saves them
+func WriteLogisim(out io.Writer, cells []uint32) error {
+	items := make([]string, 0)
+	for n := 0; n < len(cells); {
+		run := 1
+		for n+run < len(cells) && cells[n+run] == cells[n] {
+			run++
+		}
+		if run < 4 {
+			run = 1
+			items = append(items, fmt.Sprintf("%x", cells[n]))
+		} else {
+			items = append(items, fmt.Sprintf("%d*%x", run, cells[n]))
+		}
+		n += run
+	}
+
+	var sb strings.Builder
+	sb.WriteString("v2.0 raw\n")
+	for n := 0; n < len(items); n += 8 {
+		sb.WriteString(strings.Join(items[n:min(n+8, len(items))], " ") + "\n")
+	}
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+// Writes the image of a segment: the text as 32-bit words, the data as
+// bytes, the cells of a byte addressed RAM. The text fails to export when an
+// instruction has no machine code, as the ROM would not run what the
+// simulator does
+func (p *Program) Export(out io.Writer, segment, format string) error {
+	var b []byte
+	var cells []uint32
+	switch segment {
+	case textSegment:
+		if err := p.CheckEncoding(); err != nil {
+			return err
+		}
+		b = p.TextImage()
+		cells = p.Words()
+	case dataSegment:
+		data, err := p.DataImage()
+		if err != nil {
+			return err
+		}
+		b = data
+		for _, v := range data {
+			cells = append(cells, uint32(v))
+		}
+	default:
+		return fmt.Errorf("Unknown segment %s, expected %s or %s", segment, textSegment, dataSegment)
+	}
+
+	switch format {
+	case intelHex:
+		return WriteIntelHex(out, b)
+	case logisim:
+		return WriteLogisim(out, cells)
+	}
+	return fmt.Errorf("Unknown format %s, expected one of %v", format, exportFormats)
+}
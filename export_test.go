@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIntelHex(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteIntelHex(&out, []byte{0x20, 0x01, 0x00, 0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if want := ":0400000020010003D8\n:00000001FF\n"; out.String() != want {
+		t.Errorf("Got %q, want %q", out.String(), want)
+	}
+
+	// Past 64 KiB, with an extended linear address record
+	out.Reset()
+	if err := WriteIntelHex(&out, make([]byte, 0x10010)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if want := ":020000040001F9"; lines[0x1000] != want {
+		t.Errorf("Record %d = %s, want %s", 0x1000, lines[0x1000], want)
+	}
+}
+
+func TestLogisim(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteLogisim(&out, []uint32{0x20010003, 0, 0, 0, 0, 0, 7, 7, 0xC, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	want := "v2.0 raw\n20010003 5*0 7 7 c 1 2 3\n4 5\n"
+	if out.String() != want {
+		t.Errorf("Got %q, want %q", out.String(), want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	p, err := Assemble("prog.s", ".data\nx: .word 7\ns: .asciiz \"hi\"\n.text\nla $t0 x\nlw $t1 0($t0)\nhalt\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		segment, format string
+		want            string
+	}{
+		{textSegment, intelHex, ":0C000000200800008D0900000000000C2A\n:00000001FF\n"},
+		{textSegment, logisim, "v2.0 raw\n20080000 8d090000 c\n"},
+		{dataSegment, intelHex, ":040000000768690024\n:00000001FF\n"},
+		{dataSegment, logisim, "v2.0 raw\n7 68 69 0\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := p.Export(&out, tt.segment, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s %s = %q, want %q", tt.segment, tt.format, out.String(), tt.want)
+		}
+	}
+
+	// The text image loads back as machine code
+	var out bytes.Buffer
+	if err := p.Export(&out, textSegment, intelHex); err != nil {
+		t.Fatal(err)
+	}
+	words, err := ReadWords("prog.hex", out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, w := range p.Words() {
+		if words[n] != w {
+			t.Errorf("Word %d = 0x%08X, want 0x%08X", n, words[n], w)
+		}
+	}
+
+	if _, err := ReadWords("prog.hex", []byte(":0400000020010003D9\n")); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Got error %v, want a checksum error", err)
+	}
+}
+
+func TestExportUnencoded(t *testing.T) {
+	p, err := Assemble("prog.s", "addi R0 R1 one\nhalt\none .fill 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := p.Export(&out, textSegment, intelHex); err == nil || !strings.Contains(err.Error(), "prog.s:1:1: addi with data label one") {
+		t.Errorf("Got error %v, want addi with data label at line 1", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Wrote %q", out.String())
+	}
+
+	// The data does not depend on the machine code
+	if err := p.Export(&out, dataSegment, logisim); err != nil {
+		t.Error(err)
+	}
+}
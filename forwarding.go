@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Forwarding paths into the execute stage
+const (
+	ExToEx  = "EX→EX"
+	MemToEx = "MEM→EX"
+)
+
+// Bypass of a result not written back yet to an operand of the instruction
+// entering execute
+type Forward struct {
+	Register string
+	Path     string
+	Value    int64
+}
+
+// Each path can be enabled on its own. EX→EX takes the result of the previous
+// instruction from the EX/MEM register, MEM→EX takes the result, or the
+// loaded word, of the one before it from the MEM/WB register.
+type ForwardingUnit struct {
+	ExToEx  bool
+	MemToEx bool
+}
+
+// Loads only have their value after the memory access, so a load in exe
+// can not be bypassed
+func (f ForwardingUnit) CanForward(stage *Stage, producer *Instruction) bool {
+	switch stage.Nickname {
+	case "exe":
+		return f.ExToEx && producer.Opcode != LW
+	case "mem":
+		return f.MemToEx
+	}
+	return false
+}
+
+// Operands of the instruction in decode that are bypassed from the stages
+// ahead instead of being read from the registers
+func (f ForwardingUnit) Resolve(dec *Instruction, ahead []*Stage, pipe Pipeline) []Forward {
+	if dec == nil || dec.IsBubble() {
+		return nil
+	}
+
+	forwards := make([]Forward, 0)
+	for _, src := range SourceRegisters(dec, pipe) {
+		stage, producer := pendingWrite(src, ahead)
+		if producer == nil || !f.CanForward(stage, producer) {
+			continue
+		}
+
+		path := ExToEx
+		if stage.Nickname == "mem" {
+			path = MemToEx
+		}
+		forwards = append(forwards, Forward{
+			Register: src,
+			Path:     path,
+			Value:    writeBackValue(producer),
+		})
+	}
+	return forwards
+}
+
+func (f ForwardingUnit) String() string {
+	paths := make([]string, 0)
+	if f.ExToEx {
+		paths = append(paths, ExToEx)
+	}
+	if f.MemToEx {
+		paths = append(paths, MemToEx)
+	}
+	if len(paths) == 0 {
+		return "off"
+	}
+	return strings.Join(paths, " ")
+}
+
+// Paths enabled by a comma separated list of ex and mem, or on and off for
+// both of them
+func ParseForwarding(s string) (ForwardingUnit, error) {
+	var f ForwardingUnit
+	for _, path := range strings.Split(s, ",") {
+		switch strings.TrimSpace(path) {
+		case "on":
+			f.ExToEx, f.MemToEx = true, true
+		case "off":
+		case "ex":
+			f.ExToEx = true
+		case "mem":
+			f.MemToEx = true
+		default:
+			return f, fmt.Errorf("Unknown forwarding path %s, expected on, off, ex or mem", path)
+		}
+	}
+	return f, nil
+}
+
+// Replace the values read by decode with the bypassed ones
+func ForwardOperation(i *Instruction, forwards []Forward) {
+	for _, f := range forwards {
+		if i.Forwarded == nil {
+			i.Forwarded = make(map[string]string)
+		}
+		i.Operands[f.Register] = f.Value
+		i.Forwarded[f.Register] = f.Path
+		Info("Forwarding %s = %d to %v through %s\n", f.Register, f.Value, i, f.Path)
+	}
+}
+
+// Registers bypassed to the instruction, as "R1←EX→EX"
+func forwardedView(i *Instruction) string {
+	regs := make([]string, 0, len(i.Forwarded))
+	for reg, path := range i.Forwarded {
+		regs = append(regs, fmt.Sprintf("%s←%s", reg, path))
+	}
+	sort.Strings(regs)
+	return strings.Join(regs, " ")
+}
@@ -0,0 +1,44 @@
+package main
+
+// Read after write hazard between the instruction in decode and an older one
+// that did not write its result back yet
+type Hazard struct {
+	Register string
+	Stage    string
+	Producer *Instruction
+}
+
+// Youngest instruction ahead that will write reg. Stages must be ordered
+// from the closest to decode to the farthest
+func pendingWrite(reg string, ahead []*Stage) (*Stage, *Instruction) {
+	for _, stage := range ahead {
+		producer := stage.CurrInstruction
+		if producer == nil || producer.IsBubble() {
+			continue
+		}
+		if dest, ok := DestinationRegister(producer); ok && dest == reg {
+			return stage, producer
+		}
+	}
+	return nil, nil
+}
+
+// The decode stage reads the registers when handing the instruction to
+// execute, while results are only committed by wrb. Registers are written in
+// the first half of the cycle and read in the second, so the instruction in
+// wrb never conflicts: only the stages between them, exe and mem, are checked.
+// Dependencies the forwarding unit can bypass are not hazards.
+func DetectHazard(dec *Instruction, ahead []*Stage, fwd ForwardingUnit, pipe Pipeline) (Hazard, bool) {
+	if dec == nil || dec.IsBubble() {
+		return Hazard{}, false
+	}
+
+	for _, src := range SourceRegisters(dec, pipe) {
+		stage, producer := pendingWrite(src, ahead)
+		if producer == nil || fwd.CanForward(stage, producer) {
+			continue
+		}
+		return Hazard{Register: src, Stage: stage.Nickname, Producer: producer}, true
+	}
+	return Hazard{}, false
+}
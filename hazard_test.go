@@ -0,0 +1,148 @@
+package main
+
+import "testing"
+
+func TestDetectHazard(t *testing.T) {
+	pipeline := &PipelineNOOP{
+		Data: map[string]int{
+			"ten": 0,
+		},
+	}
+
+	tests := []struct {
+		name  string
+		dec   *Instruction
+		exe   *Instruction
+		mem   *Instruction
+		want  bool
+		stage string
+	}{
+		{
+			name:  "exe produces source",
+			dec:   &Instruction{Opcode: ADD, Op1: "R3", Op2: "R1", Op3: "R2"},
+			exe:   &Instruction{Opcode: ADD, Op1: "R2", Op2: "R4", Op3: "R5"},
+			want:  true,
+			stage: "exe",
+		},
+		{
+			name:  "mem produces base register",
+			dec:   &Instruction{Opcode: LW, Op1: "R3", Op2: "4(R1)"},
+			mem:   &Instruction{Opcode: ADDI, Op1: "R0", Op2: "R1", Op3: "ten"},
+			want:  true,
+			stage: "mem",
+		},
+		{
+			name: "label is not a register",
+			dec:  &Instruction{Opcode: ADDI, Op1: "R0", Op2: "R1", Op3: "ten"},
+			exe:  &Instruction{Opcode: ADD, Op1: "Rten", Op2: "R1", Op3: "R1"},
+		},
+		{
+			name: "store does not write registers",
+			dec:  &Instruction{Opcode: ADD, Op1: "R3", Op2: "R1", Op3: "R2"},
+			exe:  &Instruction{Opcode: SW, Op1: "R1", Op2: "0(R2)"},
+		},
+		{
+			name: "bubble",
+			dec:  &Instruction{Opcode: BEQ, Op1: "R1", Op2: "R2", Op3: "loop"},
+			exe:  NewBubble(),
+		},
+	}
+
+	for _, tt := range tests {
+		ahead := []*Stage{
+			{Nickname: "exe", CurrInstruction: tt.exe},
+			{Nickname: "mem", CurrInstruction: tt.mem},
+		}
+
+		hazard, got := DetectHazard(tt.dec, ahead, ForwardingUnit{}, pipeline)
+		if got != tt.want {
+			t.Errorf("%s: hazard = %v, want %v", tt.name, got, tt.want)
+		}
+		if got && hazard.Stage != tt.stage {
+			t.Errorf("%s: hazard in %s, want %s", tt.name, hazard.Stage, tt.stage)
+		}
+	}
+}
+
+func TestDetectHazardForwarding(t *testing.T) {
+	pipeline := &PipelineNOOP{}
+	fwd := ForwardingUnit{ExToEx: true, MemToEx: true}
+
+	dec := &Instruction{Opcode: ADD, Op1: "R3", Op2: "R1", Op3: "R2"}
+	add := &Instruction{Opcode: ADD, Op1: "R1", Op2: "R4", Op3: "R5"}
+	lw := &Instruction{Opcode: LW, Op1: "R2", Op2: "0(R0)"}
+
+	ahead := []*Stage{
+		{Nickname: "exe", CurrInstruction: add},
+		{Nickname: "mem", CurrInstruction: lw},
+	}
+	if _, got := DetectHazard(dec, ahead, fwd, pipeline); got {
+		t.Errorf("ALU result and loaded word should be forwarded")
+	}
+
+	ahead = []*Stage{
+		{Nickname: "exe", CurrInstruction: lw},
+		{Nickname: "mem", CurrInstruction: add},
+	}
+	hazard, got := DetectHazard(dec, ahead, fwd, pipeline)
+	if !got || hazard.Register != "R2" {
+		t.Errorf("Load use should stall on R2, got %v %v", got, hazard.Register)
+	}
+
+	fwd.ExToEx = false
+	ahead = []*Stage{
+		{Nickname: "exe", CurrInstruction: add},
+	}
+	if _, got := DetectHazard(dec, ahead, fwd, pipeline); !got {
+		t.Errorf("Disabled EX→EX path should stall")
+	}
+}
+
+func TestForwardingResolve(t *testing.T) {
+	pipeline := &PipelineNOOP{}
+	fwd := ForwardingUnit{ExToEx: true, MemToEx: true}
+
+	dec := &Instruction{Opcode: SUB, Op1: "R3", Op2: "R1", Op3: "R2"}
+	newer := &Instruction{Opcode: ADD, Op1: "R1", Result: 7}
+	older := &Instruction{Opcode: ADD, Op1: "R1", Result: 3}
+	lw := &Instruction{Opcode: LW, Op1: "R2", MemRead: true, MemValue: -4}
+
+	ahead := []*Stage{
+		{Nickname: "exe", CurrInstruction: newer},
+		{Nickname: "mem", CurrInstruction: older},
+	}
+	got := fwd.Resolve(dec, ahead, pipeline)
+	if len(got) != 1 || got[0].Value != 7 || got[0].Path != ExToEx {
+		t.Errorf("Resolve = %v, want R1 = 7 through %s", got, ExToEx)
+	}
+
+	ahead = []*Stage{
+		{Nickname: "exe", CurrInstruction: NewBubble()},
+		{Nickname: "mem", CurrInstruction: lw},
+	}
+	got = fwd.Resolve(dec, ahead, pipeline)
+	if len(got) != 1 || got[0].Value != -4 || got[0].Path != MemToEx {
+		t.Errorf("Resolve = %v, want R2 = -4 through %s", got, MemToEx)
+	}
+}
+
+func TestParseForwarding(t *testing.T) {
+	tests := map[string]ForwardingUnit{
+		"on":     {ExToEx: true, MemToEx: true},
+		"off":    {},
+		"ex":     {ExToEx: true},
+		"mem":    {MemToEx: true},
+		"ex,mem": {ExToEx: true, MemToEx: true},
+	}
+
+	for s, want := range tests {
+		got, err := ParseForwarding(s)
+		if err != nil || got != want {
+			t.Errorf("ParseForwarding(%s) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+
+	if _, err := ParseForwarding("wb"); err == nil {
+		t.Error("Expected error for unknown path")
+	}
+}
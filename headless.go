@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Runs the pipeline without the TUI until it halts or maxCycles clocks have
+// passed (0 for no limit), then dumps the final state to out. Events must be
+// consumed meanwhile, see drainEvents
+func RunHeadless(p *PipelineFile, maxCycles int, out io.Writer) error {
+	for !p.Halted {
+		if maxCycles > 0 && p.Cycles >= maxCycles {
+			break
+		}
+		p.Tick()
+	}
+
+	dumpState(p, out)
+
+	if !p.Halted {
+		return fmt.Errorf("Cycle limit of %d reached without halt", maxCycles)
+	}
+	return nil
+}
+
+// Nobody is listening to the events without the TUI, but they are still sent
+// while loading the program and on every clock. Errors go to stderr, and so do the other messages in debug
+func drainEvents(done chan struct{}) {
+	for {
+		select {
+		case msg := <-events:
+			if d, ok := msg.(debugMsg); ok && (debug || strings.HasPrefix(d.message, "ERROR")) {
+				fmt.Fprint(os.Stderr, d.message)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func dumpState(p *PipelineFile, out io.Writer) {
+	fmt.Fprintf(out, "Cycles: %d\n", p.Cycles)
+	fmt.Fprintf(out, "Instructions: %d\n", p.Retired)
+	fmt.Fprintf(out, "CPI: %.2f\n", cpi(p.Cycles, p.Retired))
+	b := p.Prediction
+	fmt.Fprintf(out, "Branches: %d (%d mispredicted, %.0f%% accuracy)\n", b.Branches, b.Mispredicted, 100*b.Accuracy())
+	fmt.Fprintf(out, "Jumps: %d\n", b.Jumps)
+	fmt.Fprintf(out, "Misprediction penalty: %d cycles\n", b.Flushed)
+
+	fmt.Fprintln(out, "\nRegisters:")
+	for i := 0; i < p.Regs.Count(); i++ {
+		name := p.Regs.Name(i)
+		v, _ := p.Regs.Get(name)
+		fmt.Fprintf(out, "%s\t%s\t%s\n", name, abiName(i), formatWord(v, p.Regs.Width(), displayFormat))
+	}
+	for _, name := range []string{HI, LO} {
+		v, _ := p.Regs.Get(name)
+		fmt.Fprintf(out, "%s\t\t%s\n", name, formatWord(v, p.Regs.Width(), displayFormat))
+	}
+
+	// Same layout as the TUI, only rows with some non zero byte
+	fmt.Fprintln(out, "\nMemory:")
+	data := p.Mem.Dump()
+	for addr := 0; addr < len(data); addr += memoryRowSize {
+		row := data[addr:min(addr+memoryRowSize, len(data))]
+		if strings.Trim(string(row), "\x00") == "" {
+			continue
+		}
+		fmt.Fprintf(out, "%04X\t% X\n", addr, row)
+	}
+}
+
+// Clocks per retired instruction, 0 when nothing retired
+func cpi(cycles, retired int) float64 {
+	if retired == 0 {
+		return 0
+	}
+	return float64(cycles) / float64(retired)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunHeadless(t *testing.T) {
+	p := newTestPipeline(t, loopProgram, fullForwarding, "not-taken")
+
+	var out bytes.Buffer
+	if err := RunHeadless(p, 0, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"Cycles: 17\n", "Instructions: 11\n", "CPI: 1.55\n", "Branches: 3 (1 mispredicted, 67% accuracy)\n", "Jumps: 2\n", "Misprediction penalty: 2 cycles\n", "R1\t$at\t-1\n", "0000\tFF 03 00"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunHeadlessCycleLimit(t *testing.T) {
+	p := newTestPipeline(t, loopProgram, fullForwarding, "not-taken")
+
+	var out bytes.Buffer
+	if err := RunHeadless(p, 10, &out); err == nil {
+		t.Error("Expected cycle limit error")
+	}
+	if p.Cycles != 10 || p.Halted {
+		t.Errorf("Cycles = %d, halted = %v, want 10 and not halted", p.Cycles, p.Halted)
+	}
+}
+
+func TestRunHeadlessWithoutHalt(t *testing.T) {
+	tests := []struct {
+		program []string
+		cycles  int
+		r2      int64
+	}{
+		{[]string{"addi R0 R1 one", "add 2 1 1", "one .fill 1"}, 8, 2}, // .fill goes down the pipeline too
+		{nil, 1, 0}, // Empty file
+	}
+
+	for _, tt := range tests {
+		p := newTestPipeline(t, tt.program, fullForwarding, "not-taken")
+
+		var out bytes.Buffer
+		if err := RunHeadless(p, 0, &out); err != nil {
+			t.Fatalf("%v: %v", tt.program, err)
+		}
+		r2, _ := p.Regs.Get("R2")
+		if p.Cycles != tt.cycles || r2 != tt.r2 {
+			t.Errorf("%v: cycles = %d, R2 = %d, want %d and %d", tt.program, p.Cycles, r2, tt.cycles, tt.r2)
+		}
+	}
+}
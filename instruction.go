@@ -1,6 +1,9 @@
 package main
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type Opcode string
 
@@ -9,27 +12,57 @@ const (
 	ADDI Opcode = "addi"
 	SUB  Opcode = "sub"
 	SUBI Opcode = "subi"
+
+	AND  Opcode = "and"
+	OR   Opcode = "or"
+	XOR  Opcode = "xor"
+	NOR  Opcode = "nor"
+	SLT  Opcode = "slt"
+	SLTU Opcode = "sltu"
+	SLL  Opcode = "sll"
+	SRL  Opcode = "srl"
+	SRA  Opcode = "sra"
+	SLLV Opcode = "sllv"
+	SRLV Opcode = "srlv"
+	SRAV Opcode = "srav"
+
+	ANDI  Opcode = "andi"
+	ORI   Opcode = "ori"
+	XORI  Opcode = "xori"
+	SLTI  Opcode = "slti"
+	SLTIU Opcode = "sltiu"
+	LUI   Opcode = "lui"
+
+	MULT  Opcode = "mult"
+	MULTU Opcode = "multu"
+	DIV   Opcode = "div"
+	DIVU  Opcode = "divu"
+	MFHI  Opcode = "mfhi"
+	MFLO  Opcode = "mflo"
+
+	LW   Opcode = "lw"
+	SW   Opcode = "sw"
 	BEQ  Opcode = "beq"
+	BNE  Opcode = "bne"
+	BLEZ Opcode = "blez"
+	BGTZ Opcode = "bgtz"
+	BLTZ Opcode = "bltz"
+	BGEZ Opcode = "bgez"
 	J    Opcode = "j"
+	JAL  Opcode = "jal"
+	JR   Opcode = "jr"
+	JALR Opcode = "jalr"
 	HALT Opcode = "halt"
 	NOOP Opcode = "noop"
 )
 
+// Directive that stores a value in data memory: label .fill value
+const FILL = ".fill"
+
 func (o Opcode) String() string {
 	return string(o)
 }
 
-func IsOpcode(s string) bool {
-	o := Opcode(s)
-	return ADD == o ||
-		ADDI == o ||
-		SUB == o ||
-		SUBI == o ||
-		BEQ == o ||
-		J == o ||
-		NOOP == o
-}
-
 type Instruction struct {
 	Opcode Opcode
 	Op1    string
@@ -38,10 +71,102 @@ type Instruction struct {
 	Temp1  string
 	Temp2  string
 	Temp3  string
-	Valid  bool
+
+	// Immediate operand, already sign or zero extended by the assembler
+	Imm       int64
+	Immediate bool
+
+	Valid   bool
+	Bubble  bool
+	Flushed bool
+
+	// Source line assembled into the instruction, and its number in the
+	// file, which differs from the PC when there are comments or blank lines
+	Raw  string
+	PC   int
+	Line int
+
+	// Machine code, see Encode
+	Encoding Encoding
+
+	// Pseudo-instruction the instruction was expanded from, as written, and
+	// which of its native instructions it is
+	Pseudo string
+	Part   int
+	Parts  int
+
+	// Branch prediction made by fetch and the outcome resolved by execute
+	Target    int
+	Predicted bool
+	Taken     bool
+
+	// Source register values read by the decode stage
+	Operands map[string]int64
+	// Operands bypassed by the forwarding unit and their path
+	Forwarded map[string]string
+
+	// Memory access performed by the mem stage
+	MemRead  bool
+	MemWrite bool
+	Address  int
+	MemValue int64
+
+	// Register written by the write back stage
+	RegWrite bool
+	Dest     string
+	Result   int64
+
+	// HI and LO written by the multiply and divide unit
+	HiLoWrite bool
+	Hi        int64
+	Lo        int64
+}
+
+// No operation inserted by the hazard unit while the decode stage is stalled
+func NewBubble() *Instruction {
+	return &Instruction{
+		Opcode: NOOP,
+		Bubble: true,
+	}
+}
+
+// Bubbles and instructions flushed from the wrong path go through the
+// remaining stages without doing anything
+func (i Instruction) IsBubble() bool {
+	return i.Bubble || i.Flushed
+}
+
+func (i Instruction) IsBranch() bool {
+	switch i.Opcode {
+	case BEQ, BNE, BLEZ, BGTZ, BLTZ, BGEZ, J, JAL, JR, JALR:
+		return true
+	}
+	return false
+}
+
+// Branches taken or not depending on their registers, the ones the predictor
+// is consulted for
+func (i Instruction) IsConditional() bool {
+	switch i.Opcode {
+	case BEQ, BNE, BLEZ, BGTZ, BLTZ, BGEZ:
+		return true
+	}
+	return false
+}
+
+// Jumps to a PC held in a register, only known once executed
+func (i Instruction) IsRegisterJump() bool {
+	return i.Opcode == JR || i.Opcode == JALR
 }
 
 func (i Instruction) String() string {
+	if i.Bubble {
+		return "bubble"
+	}
+	if i.Pseudo != "" {
+		return fmt.Sprintf("%s (%d/%d)", i.Pseudo, i.Part, i.Parts)
+	}
+
 	var sb strings.Builder
 	sb.WriteString(i.Opcode.String())
 
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/binary"
+	"flag"
 	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var events chan interface{} = make(chan interface{}, 20)
-var debug = true
+var debug = false
 
 func joinMessage(category string, format string, v ...any) {
 	message := fmt.Sprintf(format, v...)
@@ -28,17 +34,164 @@ func Debug(format string, v ...any) {
 }
 
 var numRegisters = 32
-var registers map[string]int8
+var registerWidth = 8
+var displayFormat = "dec"
+
+var memorySize = 256
+var memoryOrder binary.ByteOrder = binary.BigEndian
+var endianness = "big"
+
+var forwarding = ForwardingUnit{ExToEx: true, MemToEx: true}
+var predictorName = "not-taken"
+
+// Exit codes
+const (
+	exitError      = 1 // Program could not be loaded or run
+	exitUsage      = 2 // Invalid arguments
+	exitCycleLimit = 3 // Headless run reached --max-cycles before halt
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [program]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s asm [options] program\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s disasm program.hex\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s export [options] program\n\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Simulates the program (instrucoes.txt by default) in a 5 stage MIPS pipeline.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "Programs ending in .hex or .bin are machine code. asm and disasm convert between both,\nand export writes Intel HEX and Logisim memory images.\n\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+// Initial register values, as in R1=5,R2=-3
+func parseRegisters(s string, regs *RegisterFile) error {
+	if s == "" {
+		return nil
+	}
+	for _, assignment := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(assignment), "=")
+		if !ok {
+			return fmt.Errorf("Invalid register assignment '%s', expected name=value", assignment)
+		}
+		name = getRegisterName(name)
+		if _, err := regs.Get(name); err != nil {
+			return err
+		}
+		if name == "R0" {
+			return fmt.Errorf("Register R0 is hard-wired to zero")
+		}
+		v, err := strconv.ParseInt(value, 10, regs.Width())
+		if err != nil {
+			return fmt.Errorf("Invalid value '%s' for register %s", value, name)
+		}
+		regs.Set(name, v)
+	}
+	return nil
+}
+
+func fail(code int, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(code)
+}
 
 func main() {
-	registers = make(map[string]int8)
-	for i := 0; i < numRegisters; i++ {
-		nick := fmt.Sprintf("R%d", i)
-		registers[nick] = 0
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fail(exitError, err)
+			}
+			return
+		}
+	}
+
+	headless := flag.Bool("headless", false, "run to halt without the TUI and print the final state")
+	maxCycles := flag.Int("max-cycles", 0, "stop a headless run after `n` cycles, 0 for no limit")
+	forwardingPaths := flag.String("forwarding", "on", "forwarding `paths`: on, off, or a comma separated list of ex and mem")
+	flag.StringVar(&predictorName, "predictor", predictorName, fmt.Sprintf("branch `predictor`, one of %s", strings.Join(predictors, ", ")))
+	flag.BoolVar(&debug, "debug", false, "log the debug messages")
+	autoplay := flag.Duration("autoplay", 0, "start the TUI ticking every `delay`, as in 500ms")
+	initial := flag.String("registers", "", "initial register `values`, as in R1=5,R2=-3")
+	flag.IntVar(&numRegisters, "register-count", numRegisters, "number of general purpose `registers`")
+	flag.IntVar(&registerWidth, "width", registerWidth, fmt.Sprintf("register and memory word width in `bits`, one of %v", registerWidths))
+	flag.IntVar(&memorySize, "memory-size", memorySize, "data memory size in `bytes`")
+	flag.StringVar(&endianness, "endian", endianness, "memory byte `order`, big or little")
+	flag.IntVar(&mulDivLatency, "muldiv-latency", mulDivLatency, "`clocks` a multiplication or division holds the execute stage")
+	timeline := flag.String("timeline", "", "write the pipeline diagram to `file` when the run ends")
+	flag.StringVar(&displayFormat, "format", displayFormat, fmt.Sprintf("register values `format`, one of %s", strings.Join(displayFormats, ", ")))
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	filename := "instrucoes.txt"
+	if flag.NArg() == 1 {
+		filename = flag.Arg(0)
+	}
+
+	registers, err := NewRegisterFile(numRegisters, registerWidth)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	wordSize = registerWidth / 8
+	if err := checkMemorySize(memorySize); err != nil {
+		fail(exitUsage, err)
+	}
+	memoryOrder, err = ParseByteOrder(endianness)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	if !slices.Contains(displayFormats, displayFormat) {
+		fail(exitUsage, fmt.Errorf("Unknown format %s, expected one of %v", displayFormat, displayFormats))
+	}
+	if _, err := NewMulDivUnit(mulDivLatency, registerWidth); err != nil {
+		fail(exitUsage, err)
+	}
+	// Initial values may move the stack pointer
+	registers.Set(stackPointer, stackTop(memorySize))
+	if err := parseRegisters(*initial, registers); err != nil {
+		fail(exitUsage, err)
+	}
+
+	forwarding, err = ParseForwarding(*forwardingPaths)
+	if err != nil {
+		fail(exitUsage, err)
 	}
 
-	pipeline := NewPipeline("instrucoes.txt")
-	pipeline.Start()
+	predictor, err := NewPredictor(predictorName)
+	if err != nil {
+		fail(exitUsage, err)
+	}
 
-	RunCmd(pipeline, registers, events)
+	if *headless {
+		done := make(chan struct{})
+		defer close(done)
+		go drainEvents(done)
+	}
+
+	memory := NewMemory(memorySize, memoryOrder)
+
+	pipeline, err := NewPipeline(filename, memory, registers, forwarding, predictor)
+	if err != nil {
+		fail(exitError, err)
+	}
+
+	if *headless {
+		err := RunHeadless(pipeline, *maxCycles, os.Stdout)
+		if *timeline != "" {
+			if err := writeTimeline(*timeline, &pipeline.Timeline); err != nil {
+				fail(exitError, err)
+			}
+		}
+		if err != nil {
+			fail(exitCycleLimit, err)
+		}
+		return
+	}
+
+	RunCmd(pipeline, events, *autoplay)
+	if *timeline != "" {
+		if err := writeTimeline(*timeline, &pipeline.Timeline); err != nil {
+			fail(exitError, err)
+		}
+	}
 }
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestParseRegisters(t *testing.T) {
+	regs, _ := NewRegisterFile(3, 8)
+
+	if err := parseRegisters("R1=5, 2=-3", regs); err != nil {
+		t.Fatal(err)
+	}
+	r1, _ := regs.Get("R1")
+	r2, _ := regs.Get("R2")
+	if r1 != 5 || r2 != -3 {
+		t.Errorf("R1 = %d, R2 = %d, want 5 and -3", r1, r2)
+	}
+
+	for _, s := range []string{"R1", "R3=1", "R1=128", "R1=x", "R0=1"} {
+		if err := parseRegisters(s, regs); err == nil {
+			t.Errorf("Expected error for %s", s)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Bytes of a memory word, the register width. Registers are 8 bits wide by
+// default, so a word is a single byte
+var wordSize = 1
+
+// Byte addressable data memory. Values wider than one byte are laid out
+// following the configured byte order.
+type Memory struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// Byte orders memory can be configured with
+var byteOrders = map[string]binary.ByteOrder{
+	"big":    binary.BigEndian,
+	"little": binary.LittleEndian,
+}
+
+// Byte order from its name, big or little
+func ParseByteOrder(name string) (binary.ByteOrder, error) {
+	order, ok := byteOrders[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown endianness %s, expected big or little", name)
+	}
+	return order, nil
+}
+
+// The memory holds whole words, so the stack pointer starts aligned, and
+// every address fits a register, so $sp starts at the top of memory
+func checkMemorySize(size int) error {
+	if size < wordSize || size%wordSize != 0 {
+		return fmt.Errorf("Invalid memory size %d, expected a positive multiple of %d bytes", size, wordSize)
+	}
+	if limit := 1 << (8 * wordSize); size > limit {
+		return fmt.Errorf("Invalid memory size %d, %d bit registers address up to %d bytes", size, 8*wordSize, limit)
+	}
+	return nil
+}
+
+func NewMemory(size int, order binary.ByteOrder) *Memory {
+	return &Memory{
+		data:  make([]byte, size),
+		order: order,
+	}
+}
+
+func (m *Memory) Size() int {
+	return len(m.data)
+}
+
+func (m *Memory) Order() binary.ByteOrder {
+	return m.order
+}
+
+// Copy of the memory contents, safe to be used by the TUI
+func (m *Memory) Dump() []byte {
+	b := make([]byte, len(m.data))
+	copy(b, m.data)
+	return b
+}
+
+func (m *Memory) check(addr, size int) error {
+	if size != 1 && size != 2 && size != 4 {
+		return fmt.Errorf("Invalid access size of %d bytes", size)
+	}
+	if addr < 0 || addr+size > len(m.data) {
+		return fmt.Errorf("Address %d is out of memory bounds", addr)
+	}
+	if addr%size != 0 {
+		return fmt.Errorf("Address %d is not aligned to %d bytes", addr, size)
+	}
+	return nil
+}
+
+// Read size bytes starting at addr, sign extending the value
+func (m *Memory) Load(addr, size int) (int, error) {
+	if err := m.check(addr, size); err != nil {
+		return 0, err
+	}
+	b := m.data[addr : addr+size]
+	switch size {
+	case 2:
+		return int(int16(m.order.Uint16(b))), nil
+	case 4:
+		return int(int32(m.order.Uint32(b))), nil
+	default:
+		return int(int8(b[0])), nil
+	}
+}
+
+// Write the size lower bytes of value starting at addr
+func (m *Memory) Store(addr, size int, value int) error {
+	if err := m.check(addr, size); err != nil {
+		return err
+	}
+	b := m.data[addr : addr+size]
+	switch size {
+	case 2:
+		m.order.PutUint16(b, uint16(value))
+	case 4:
+		m.order.PutUint32(b, uint32(value))
+	default:
+		b[0] = byte(value)
+	}
+	return nil
+}
+
+// Bytes from addr to addr+size, used to notify memory changes
+func (m *Memory) Slice(addr, size int) []byte {
+	b := make([]byte, size)
+	copy(b, m.data[addr:addr+size])
+	return b
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestMemoryByteOrder(t *testing.T) {
+	tests := []struct {
+		order binary.ByteOrder
+		want  []byte
+	}{
+		{binary.BigEndian, []byte{0x12, 0x34, 0x56, 0x78}},
+		{binary.LittleEndian, []byte{0x78, 0x56, 0x34, 0x12}},
+	}
+
+	for _, tt := range tests {
+		mem := NewMemory(8, tt.order)
+		mem.Store(4, 4, 0x12345678)
+
+		got := mem.Slice(4, 4)
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v stored % X, want % X", tt.order, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMemorySignExtend(t *testing.T) {
+	mem := NewMemory(8, binary.BigEndian)
+	mem.Store(2, 2, -300)
+
+	got, _ := mem.Load(2, 2)
+	if got != -300 {
+		t.Errorf("Load half = %d, want %d", got, -300)
+	}
+}
+
+func TestMemoryUnaligned(t *testing.T) {
+	mem := NewMemory(8, binary.BigEndian)
+
+	if _, err := mem.Load(1, 4); err == nil {
+		t.Errorf("Unaligned word load should fail")
+	}
+	if err := mem.Store(6, 4, 1); err == nil {
+		t.Errorf("Out of bounds word store should fail")
+	}
+}
+
+func TestMemoryOptions(t *testing.T) {
+	if order, err := ParseByteOrder("little"); err != nil || order != binary.LittleEndian {
+		t.Errorf("ParseByteOrder(little) = %v, %v", order, err)
+	}
+	if _, err := ParseByteOrder("middle"); err == nil {
+		t.Errorf("Expected error for middle endianness")
+	}
+
+	wordSize = 4
+	defer func() { wordSize = 1 }()
+	for _, size := range []int{0, -4, 2, 258} {
+		if err := checkMemorySize(size); err == nil {
+			t.Errorf("Expected error for memory size %d", size)
+		}
+	}
+	if err := checkMemorySize(1024); err != nil {
+		t.Error(err)
+	}
+
+	// Addresses past 255 do not fit 8 bit registers
+	wordSize = 1
+	if err := checkMemorySize(1024); err == nil {
+		t.Error("Expected error for 1024 bytes with 8 bit registers")
+	}
+	if err := checkMemorySize(256); err != nil {
+		t.Error(err)
+	}
+}
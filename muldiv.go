@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+// Clocks a multiplication or division holds the execute stage
+var mulDivLatency = 4
+
+// Multiply and divide unit. Unlike the ALU it takes several clocks, holding
+// the execute stage meanwhile, so the instructions behind it stall. HI and LO
+// are written when the operation completes, and are only read by mfhi and
+// mflo in execute, which can not get there before that.
+type MulDivUnit struct {
+	Latency int
+	width   int
+
+	// Instruction in execute and the clocks left until it completes
+	curr *Instruction
+	left int
+}
+
+func NewMulDivUnit(latency, width int) (*MulDivUnit, error) {
+	if latency < 1 {
+		return nil, fmt.Errorf("Invalid multiply and divide latency %d, expected at least 1", latency)
+	}
+	return &MulDivUnit{Latency: latency, width: width}, nil
+}
+
+func isMulDiv(op Opcode) bool {
+	return op == MULT || op == MULTU || op == DIV || op == DIVU
+}
+
+// An operation started in a previous clock is still in execute
+func (u *MulDivUnit) Busy() bool {
+	return u.left > 0
+}
+
+func (u *MulDivUnit) Start(i *Instruction) {
+	u.curr = i
+	u.left = u.Latency
+}
+
+// One clock of the operation in execute. Returns the instruction when it
+// completes, so its HI and LO can be written
+func (u *MulDivUnit) Clock() (*Instruction, bool) {
+	if u.left == 0 {
+		return nil, false
+	}
+	u.left--
+	if u.left > 0 {
+		return nil, false
+	}
+	i := u.curr
+	u.curr = nil
+	return i, true
+}
+
+// HI and LO of the operation, truncated to the register width. The product
+// takes twice the width, the upper half going to HI. Divisions leave the
+// quotient in LO and the remainder in HI.
+func (u *MulDivUnit) Execute(op Opcode, x, y int64) (hi, lo int64, err error) {
+	alu := NewALU(u.width)
+	switch op {
+	case MULT:
+		p := x * y
+		hi, lo = p>>u.width, p
+	case MULTU:
+		p := alu.unsigned(x) * alu.unsigned(y)
+		hi, lo = int64(p>>u.width), int64(p)
+	case DIV:
+		if y == 0 {
+			return 0, 0, fmt.Errorf("Division by zero")
+		}
+		hi, lo = x%y, x/y
+	case DIVU:
+		if y == 0 {
+			return 0, 0, fmt.Errorf("Division by zero")
+		}
+		hi, lo = int64(alu.unsigned(x)%alu.unsigned(y)), int64(alu.unsigned(x)/alu.unsigned(y))
+	default:
+		return 0, 0, fmt.Errorf("Opcode %s does not use the multiply and divide unit", op)
+	}
+	return alu.truncate(hi), alu.truncate(lo), nil
+}
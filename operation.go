@@ -7,168 +7,496 @@ import (
 )
 
 func getRegisterName(r string) string {
+	if name, ok := parseRegister(r); ok {
+		return name
+	}
 	if strings.HasPrefix(r, "R") {
 		return r
 	}
 	return fmt.Sprintf("R%s", r)
 }
 
-func updateRegister(name string, value int8) {
-	_, ok := registers[name]
+// Write port of the register file. R0 stays 0 whatever is written
+func updateRegister(pipe Pipeline, name string, value int64) error {
+	v, err := pipe.Registers().Write(name, value)
+	if err != nil {
+		return err
+	}
+	events <- registerUpdatedMsg{name: name, value: v}
+	return nil
+}
+
+func registerExists(pipe Pipeline, name string) bool {
+	_, err := pipe.Registers().Get(name)
+	return err == nil
+}
+
+func registerNames(ops ...string) []string {
+	names := make([]string, 0, len(ops))
+	for _, op := range ops {
+		names = append(names, getRegisterName(op))
+	}
+	return names
+}
+
+// Base register of an offset(base) operand
+func baseRegister(operand string) []string {
+	_, base, ok := strings.Cut(operand, "(")
 	if !ok {
-		return
+		return nil
+	}
+	return registerNames(strings.TrimSuffix(base, ")"))
+}
+
+// I-type instructions follow MIPS, destination before source, as in
+// "addi $t0 $a0 5". addi and subi with a register or data label are the forms
+// the simulator started with and keep the source first, as in "addi R0 R1 one"
+func iOperands(i *Instruction) (dest, src string) {
+	if (i.Opcode == ADDI || i.Opcode == SUBI) && !i.Immediate {
+		return i.Op2, i.Op1
+	}
+	return i.Op1, i.Op2
+}
+
+// Registers read by the instruction
+func SourceRegisters(i *Instruction, pipe Pipeline) []string {
+	if c, ok := aluControls[i.Opcode]; ok {
+		switch c.format {
+		case rFormat:
+			return registerNames(i.Op2, i.Op3)
+		case shiftFormat:
+			return registerNames(i.Op2)
+		case iFormat:
+			_, src := iOperands(i)
+			if _, ok := pipe.Address(i.Op3); ok || i.Immediate {
+				return registerNames(src)
+			}
+			return registerNames(src, i.Op3)
+		}
+		return nil
 	}
-	registers[name] = value
-	events <- registerUpdatedMsg{name: name, value: value}
+
+	switch i.Opcode {
+	case BEQ, BNE, MULT, MULTU, DIV, DIVU:
+		return registerNames(i.Op1, i.Op2)
+	case BLEZ, BGTZ, BLTZ, BGEZ, JR, JALR:
+		return registerNames(i.Op1)
+	case LW:
+		return baseRegister(i.Op2)
+	case SW:
+		return append(registerNames(i.Op1), baseRegister(i.Op2)...)
+	}
+	return nil
+}
+
+// Register written back by the instruction, if any. Writes to R0 are
+// discarded, so they never cause hazards nor are forwarded
+func DestinationRegister(i *Instruction) (string, bool) {
+	dest := ""
+	if c, ok := aluControls[i.Opcode]; ok {
+		dest = getRegisterName(i.Op1)
+		if c.format == iFormat {
+			rd, _ := iOperands(i)
+			dest = getRegisterName(rd)
+		}
+	}
+	switch i.Opcode {
+	case LW, MFHI, MFLO:
+		dest = getRegisterName(i.Op1)
+	case JAL, JALR:
+		dest = returnRegister
+	}
+	return dest, dest != "" && dest != "R0"
+}
+
+// The decode stage reads the source registers right before handing the
+// instruction to execute, so the values are the ones committed so far
+func DecodeOperation(i *Instruction, pipe Pipeline) {
+	i.Operands = make(map[string]int64)
+	for _, name := range SourceRegisters(i, pipe) {
+		if v, err := pipe.Registers().Read(name); err == nil {
+			i.Operands[name] = v
+		}
+	}
+}
+
+// Result is only written to the register by the write back stage. It wraps
+// around at the register width, as it may be forwarded before that
+func writeRegister(pipe Pipeline, i *Instruction, name string, value int64) {
+	i.RegWrite = true
+	i.Dest = getRegisterName(name)
+	i.Result = pipe.Registers().Truncate(value)
 }
 
-// "Jump" to PC and get the value
-func spy(pc int, pipe Pipeline) int8 {
-	line := pipe.Read(pc)
-	parts := strings.Split(line, " ")
-	r, err := strconv.Atoi(parts[len(parts)-1])
+// Read the word stored by .fill at the labeled address
+func loadData(addr int, pipe Pipeline) (int64, error) {
+	v, err := pipe.Memory().Load(addr, wordSize)
 	if err != nil {
-		fmt.Printf("ERROR: R3 is not a number. Maybe it was not decoded properly")
-		return 0
+		return 0, err
+	}
+	return int64(v), nil
+}
+
+// Last operand of addi and subi: an immediate, the word at a data label or a
+// register
+func immediateOperand(i *Instruction, pipe Pipeline) (int64, error) {
+	if i.Immediate {
+		return i.Imm, nil
+	}
+	if addr, ok := pipe.Address(i.Op3); ok {
+		// Contains a label. Read the word from data memory
+		return loadData(addr, pipe)
 	}
-	return int8(r)
+	return i.Operands[getRegisterName(i.Op3)], nil
 }
 
-// Substiuindo lw: addi R0 R1 -1 = Soma R0 com neg1 e coloca no R1
-func AddiOperation(i *Instruction, pipe Pipeline) error {
-	op1, ok := registers[getRegisterName(i.Op1)]
+// Register value read by decode, or bypassed by the forwarding unit
+func operand(i *Instruction, op string) (int64, error) {
+	v, ok := i.Operands[getRegisterName(op)]
 	if !ok {
 		i.Valid = false
-		return fmt.Errorf("Register %s does not exist", i.Op1)
+		return 0, fmt.Errorf("Register %s does not exist", op)
 	}
-	_, ok = registers[getRegisterName(i.Op2)]
+	return v, nil
+}
+
+// Every arithmetic, logical, comparison and shift instruction. The ALU
+// control tells where the operands come from and what the ALU does with them.
+//
+//	add R0 R1 R2: R0 = R1 + R2
+//	sll R0 R1 4: R0 = R1 << 4
+//	andi R1 R2 0xF: R1 = R2 & 0xF
+//	addi R0 R1 neg1: R1 = R0 + neg1, the word stored by .fill
+//	lui R1 0xA: upper half of R1 = 0xA
+func ALUOperation(i *Instruction, pipe Pipeline) error {
+	c, ok := aluControls[i.Opcode]
 	if !ok {
+		return fmt.Errorf("Opcode %s does not use the ALU", i.Opcode)
+	}
+
+	var dest string
+	var x, y int64
+	var err error
+	switch c.format {
+	case rFormat:
+		dest = i.Op1
+		if x, err = operand(i, i.Op2); err != nil {
+			return err
+		}
+		y, err = operand(i, i.Op3)
+	case shiftFormat:
+		dest = i.Op1
+		x, err = operand(i, i.Op2)
+		y = i.Imm
+	case iFormat:
+		var src string
+		dest, src = iOperands(i)
+		if x, err = operand(i, src); err != nil {
+			return err
+		}
+		y, err = immediateOperand(i, pipe)
+	case upperFormat:
+		dest = i.Op1
+		y = i.Imm
+	}
+	if err != nil {
 		i.Valid = false
-		return fmt.Errorf("Register %s does not exist", i.Op2)
+		return err
 	}
-	var op3 int8
-	pc, ok := pipe.Label(i.Op3)
-	if ok {
-		// Contains a label. "Jump" to related PC to get the int8 value
-		op3 = spy(pc, pipe)
-	} else {
-		op3 = registers[getRegisterName(i.Op3)]
+
+	if !registerExists(pipe, getRegisterName(dest)) {
+		i.Valid = false
+		return fmt.Errorf("Register %s does not exist", dest)
 	}
-	updateRegister(i.Op2, op1+op3)
+	alu := NewALU(pipe.Registers().Width())
+	writeRegister(pipe, i, dest, alu.Execute(c.op, x, y))
 	return nil
 }
 
-// add R0 R1 R2
-// R0 = R1 + R2
-func AddOperation(i *Instruction, pip Pipeline) error {
-	op1Nick := getRegisterName(i.Op1)
-	op2Nick := getRegisterName(i.Op2)
-	op3Nick := getRegisterName(i.Op3)
+// mult R1 R2: HI, LO = R1 * R2
+// div R1 R2: LO = R1 / R2, HI = R1 % R2
+// HI and LO are only written when the multiply and divide unit completes, see
+// WriteHiLoOperation
+func MulDivOperation(i *Instruction, pipe Pipeline) error {
+	x, err := operand(i, i.Op1)
+	if err != nil {
+		return err
+	}
+	y, err := operand(i, i.Op2)
+	if err != nil {
+		return err
+	}
 
-	_, ok := registers[op1Nick]
-	if !ok {
+	unit := MulDivUnit{width: pipe.Registers().Width()}
+	hi, lo, err := unit.Execute(i.Opcode, x, y)
+	if err != nil {
 		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+		return err
 	}
-	op2, ok := registers[op2Nick]
-	if !ok {
-		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+	i.HiLoWrite = true
+	i.Hi = hi
+	i.Lo = lo
+	return nil
+}
+
+// Commit the result of a multiplication or division to HI and LO
+func WriteHiLoOperation(i *Instruction, pipe Pipeline) error {
+	if !i.HiLoWrite {
+		return nil
 	}
-	op3, ok := registers[op3Nick]
-	if !ok {
+	if err := updateRegister(pipe, HI, i.Hi); err != nil {
+		return err
+	}
+	return updateRegister(pipe, LO, i.Lo)
+}
+
+// mfhi R1: R1 = HI
+// HI and LO are read in execute, after any multiplication or division ahead
+// has left the unit
+func MoveFromOperation(i *Instruction, pipe Pipeline) error {
+	src := HI
+	if i.Opcode == MFLO {
+		src = LO
+	}
+	if !registerExists(pipe, getRegisterName(i.Op1)) {
 		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+		return fmt.Errorf("Register %s does not exist", i.Op1)
 	}
 
-	updateRegister(op1Nick, op2+op3)
+	v, err := pipe.Registers().Read(src)
+	if err != nil {
+		i.Valid = false
+		return err
+	}
+	writeRegister(pipe, i, i.Op1, v)
 	return nil
 }
 
-func SubiOperation(i *Instruction, pipe Pipeline) error {
-	op1, ok := registers[getRegisterName(i.Op1)]
+// Conditional branches, taken when the registers compare as the opcode says
+//
+//	beq R1 R2 loop: R1 == R2
+//	bne R1 R2 loop: R1 != R2
+//	blez R1 loop: R1 <= 0
+//	bgtz R1 loop: R1 > 0
+//	bltz R1 loop: R1 < 0
+//	bgez R1 loop: R1 >= 0
+func BranchOperation(i *Instruction, pipe Pipeline) error {
+	x, err := operand(i, i.Op1)
+	if err != nil {
+		return err
+	}
+
+	label := i.Op2
+	var taken bool
+	switch i.Opcode {
+	case BEQ, BNE:
+		y, err := operand(i, i.Op2)
+		if err != nil {
+			return err
+		}
+		label = i.Op3
+		taken = (x == y) == (i.Opcode == BEQ)
+	case BLEZ:
+		taken = x <= 0
+	case BGTZ:
+		taken = x > 0
+	case BLTZ:
+		taken = x < 0
+	case BGEZ:
+		taken = x >= 0
+	default:
+		return fmt.Errorf("Opcode %s is not a conditional branch", i.Opcode)
+	}
+	if !taken {
+		return nil
+	}
+
+	pc, ok := pipe.Label(label)
 	if !ok {
+		return fmt.Errorf("Label %s does not exist", label)
+	}
+	Debug("Jumping to %d\n", pc)
+	pipe.JumpTo(pc)
+	return nil
+}
+
+// Register jal and jalr save the return address to
+const returnRegister = "R31"
+
+// The return address is the PC of the next instruction, written back as any
+// other result
+func linkOperation(i *Instruction, pipe Pipeline) error {
+	if !registerExists(pipe, returnRegister) {
 		i.Valid = false
-		return fmt.Errorf("Register %s does not exist", i.Op1)
+		return fmt.Errorf("Register %s does not exist", returnRegister)
 	}
-	_, ok = registers[getRegisterName(i.Op2)]
+	writeRegister(pipe, i, returnRegister, int64(i.PC+1))
+	return nil
+}
+
+// Jump to labeled PC. jal also links the return address
+//
+//	j loop
+//	jal func: $ra = PC + 1
+func JOperation(i *Instruction, pipe Pipeline) error {
+	pc, ok := pipe.Label(i.Op1)
 	if !ok {
+		return fmt.Errorf("Label %s does not exist", i.Op1)
+	}
+	if i.Opcode == JAL {
+		if err := linkOperation(i, pipe); err != nil {
+			return err
+		}
+	}
+
+	Debug("Jumping to %d\n", pc)
+	pipe.JumpTo(pc)
+	return nil
+}
+
+// Jump to the PC held in a register, read as unsigned. jalr also links the
+// return address
+//
+//	jr $ra
+//	jalr R8: $ra = PC + 1
+func JrOperation(i *Instruction, pipe Pipeline) error {
+	v, err := operand(i, i.Op1)
+	if err != nil {
+		return err
+	}
+	pc := int(NewALU(pipe.Registers().Width()).unsigned(v))
+	if pipe.Read(pc) == "" {
 		i.Valid = false
-		return fmt.Errorf("Register %s does not exist", i.Op2)
+		return fmt.Errorf("Jump to PC %d, which is outside the program", pc)
 	}
-	var op3 int8
-	pc, ok := pipe.Label(i.Op3)
-	if ok {
-		// Contains a label. "Jump" to related PC to get the int8 value
-		op3 = spy(pc, pipe)
-	} else {
-		op3 = registers[getRegisterName(i.Op3)]
+	if i.Opcode == JALR {
+		if err := linkOperation(i, pipe); err != nil {
+			return err
+		}
 	}
-	updateRegister(i.Op2, op1-op3)
+
+	// Not known by fetch, unlike the labeled targets
+	i.Target = pc
+	Debug("Jumping to %d\n", pc)
+	pipe.JumpTo(pc)
 	return nil
 }
 
-// sub R0 R1 R2
-// R0 = R1 - R2
-func SubOperation(i *Instruction, pip Pipeline) error {
-	op1Nick := getRegisterName(i.Op1)
-	op2Nick := getRegisterName(i.Op2)
-	op3Nick := getRegisterName(i.Op3)
+// offset(base), where offset is a number or a data label. Both parts are
+// optional, so "neg1", "4(R2)" and "(R2)" are valid. The base register holds
+// an unsigned address, so 8 bit registers reach the whole memory
+func effectiveAddress(i *Instruction, pipe Pipeline) (int, error) {
+	offset, base, hasBase := strings.Cut(i.Op2, "(")
 
-	_, ok := registers[op1Nick]
-	if !ok {
-		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+	addr := 0
+	if hasBase {
+		name := getRegisterName(strings.TrimSuffix(base, ")"))
+		v, ok := i.Operands[name]
+		if !ok {
+			return 0, fmt.Errorf("Register %s does not exist", name)
+		}
+		addr = int(NewALU(pipe.Registers().Width()).unsigned(v))
 	}
-	op2, ok := registers[op2Nick]
-	if !ok {
+
+	if labeled, ok := pipe.Address(offset); ok {
+		return addr + labeled, nil
+	}
+	if len(offset) == 0 {
+		return addr, nil
+	}
+	n, err := strconv.Atoi(offset)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid offset %s", offset)
+	}
+	return addr + n, nil
+}
+
+// lw R1 4(R2)
+// R1 = MEM[R2 + 4]
+func LwOperation(i *Instruction, pipe Pipeline) error {
+	rt := getRegisterName(i.Op1)
+	if !registerExists(pipe, rt) {
 		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+		return fmt.Errorf("Register %s does not exist", i.Op1)
 	}
-	op3, ok := registers[op3Nick]
-	if !ok {
+
+	addr, err := effectiveAddress(i, pipe)
+	if err != nil {
 		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+		return err
 	}
 
-	updateRegister(op1Nick, op2-op3)
+	i.MemRead = true
+	i.Address = addr
+	i.RegWrite = true
+	i.Dest = rt
 	return nil
 }
 
-func BeqOperation(i *Instruction, pipe Pipeline) error {
-	op1Nick := getRegisterName(i.Op1)
-	op2Nick := getRegisterName(i.Op2)
-
-	op1, ok := registers[op1Nick]
+// sw R1 4(R2)
+// MEM[R2 + 4] = R1
+func SwOperation(i *Instruction, pipe Pipeline) error {
+	rt, ok := i.Operands[getRegisterName(i.Op1)]
 	if !ok {
 		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+		return fmt.Errorf("Register %s does not exist", i.Op1)
 	}
-	op2, ok := registers[op2Nick]
-	if !ok {
+
+	addr, err := effectiveAddress(i, pipe)
+	if err != nil {
 		i.Valid = false
-		return fmt.Errorf("ERROR: Register %s does not exist\n", i.Op1)
+		return err
 	}
-	if op1 == op2 {
-		pc, ok := pipe.Label(i.Op3)
-		if !ok {
-			return fmt.Errorf("ERROR: Label %s does not exist\n", i.Op3)
+
+	i.MemWrite = true
+	i.Address = addr
+	i.MemValue = rt
+	return nil
+}
+
+// Load or store the word at the address calculated by the execute stage
+func MemoryAccessOperation(i *Instruction, pipe Pipeline) error {
+	mem := pipe.Memory()
+
+	switch {
+	case i.MemRead:
+		v, err := mem.Load(i.Address, wordSize)
+		if err != nil {
+			i.Valid = false
+			return err
 		}
-		Debug("Jumping to %d\n", pc)
-		pipe.JumpTo(pc)
+		i.MemValue = int64(v)
+		Debug("Loaded %d from address %d\n", i.MemValue, i.Address)
+
+	case i.MemWrite:
+		if err := mem.Store(i.Address, wordSize, int(i.MemValue)); err != nil {
+			i.Valid = false
+			return err
+		}
+		Debug("Stored %d at address %d\n", i.MemValue, i.Address)
+		events <- memoryUpdatedMsg{address: i.Address, value: mem.Slice(i.Address, wordSize)}
 	}
 	return nil
 }
 
-// Jump to labeled PC
-// j loop
-func JOperation(i *Instruction, pipe Pipeline) error {
-	pc, ok := pipe.Label(i.Op1)
-	if !ok {
-		return fmt.Errorf("ERROR: Label %s does not exist\n", i.Op3)
+// Result, or the loaded word, that will be committed to the register
+func writeBackValue(i *Instruction) int64 {
+	if i.MemRead {
+		return i.MemValue
 	}
+	return i.Result
+}
 
-	Debug("Jumping to %d\n", pc)
-	pipe.JumpTo(pc)
+// Commit the result, or the loaded word, to the destination register
+func WriteBackOperation(i *Instruction, pipe Pipeline) error {
+	if !i.RegWrite {
+		return nil
+	}
+
+	value := writeBackValue(i)
+	if err := updateRegister(pipe, i.Dest, value); err != nil {
+		i.Valid = false
+		return err
+	}
 	return nil
 }
@@ -1,10 +1,26 @@
 package main
 
-import "testing"
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
 
 type PipelineNOOP struct {
 	PC     int
 	Labels map[string]int
+	Data   map[string]int
+	Mem    *Memory
+	Regs   *RegisterFile
+}
+
+func TestMain(m *testing.M) {
+	// There is no TUI consuming events while testing
+	go func() {
+		for range events {
+		}
+	}()
+	os.Exit(m.Run())
 }
 
 func (p *PipelineNOOP) Read(pc int) string {
@@ -16,88 +32,124 @@ func (p *PipelineNOOP) Label(label string) (int, bool) {
 	return pc, ok
 }
 
+func (p *PipelineNOOP) Address(label string) (int, bool) {
+	addr, ok := p.Data[label]
+	return addr, ok
+}
+
+func (p *PipelineNOOP) Memory() *Memory {
+	return p.Mem
+}
+
+func (p *PipelineNOOP) Registers() *RegisterFile {
+	return p.Regs
+}
+
+// Value of a register after the operation was written back
+func (p *PipelineNOOP) register(name string) int64 {
+	v, _ := p.Regs.Get(name)
+	return v
+}
+
+func (p *PipelineNOOP) Symbols() []Symbol {
+	return nil
+}
+
 func (p *PipelineNOOP) JumpTo(pc int) {
 	p.PC = pc
 }
 
-func (p *PipelineNOOP) Broadcast(r rune) {}
+func (p *PipelineNOOP) Tick() {}
 
 func (p *PipelineNOOP) Stages() []*Stage {
 	return []*Stage{}
 }
 
 func TestAddi(t *testing.T) {
-    var want int8 = 2
+    var want int64 = 2
 
 	pipeline := &PipelineNOOP{
 		Labels: make(map[string]int),
 	}
 
-	registers = make(map[string]int8)
-	registers["R0"] = 0
-	registers["R1"] = 0
-	registers["R2"] = 2
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R0", 0)
+	pipeline.Regs.Set("R1", 0)
+	pipeline.Regs.Set("R2", 2)
 
 	instruction := &Instruction{
-		Op1: "R0",
-		Op2: "R1",
-		Op3: "R2",
+		Opcode: ADDI,
+		Op1:    "R0",
+		Op2:    "R1",
+		Op3:    "R2",
 	}
 
-	AddiOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
 
-	got := registers["R1"]
+	got := pipeline.register("R1")
 	if got != want {
 		t.Errorf("ADDI = %d, want %d", got, want)
 	}
 }
 
 func TestAddiLabeled(t *testing.T) {
-    var want int8 = 2
+    var want int64 = 2
+
+	mem := NewMemory(16, binary.BigEndian)
+	mem.Store(3, wordSize, 2)
 
 	pipeline := &PipelineNOOP{
-		Labels: map[string]int{
-			"two": 10,
+		Data: map[string]int{
+			"two": 3,
 		},
+		Mem: mem,
 	}
 
-	registers = make(map[string]int8)
-	registers["R0"] = 0
-	registers["R1"] = 0
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R0", 0)
+	pipeline.Regs.Set("R1", 0)
 
 	instruction := &Instruction{
-		Op1: "R0",
-		Op2: "R1",
-		Op3: "two",
+		Opcode: ADDI,
+		Op1:    "R0",
+		Op2:    "R1",
+		Op3:    "two",
 	}
 
-	AddiOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
 
-	got := registers["R1"]
+	got := pipeline.register("R1")
 	if got != want {
 		t.Errorf("ADDI = %d, want %d", got, want)
 	}
 }
 
 func TestAdd(t *testing.T) {
-    var want int8 = 4
+    var want int64 = 4
 
 	pipeline := &PipelineNOOP{}
 
-	registers = make(map[string]int8)
-	registers["R1"] = 0
-	registers["R2"] = 1
-	registers["R3"] = 3
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", 0)
+	pipeline.Regs.Set("R2", 1)
+	pipeline.Regs.Set("R3", 3)
 
 	instruction := &Instruction{
-		Op1: "R1",
-		Op2: "R2",
-		Op3: "R3",
+		Opcode: ADD,
+		Op1:    "R1",
+		Op2:    "R2",
+		Op3:    "R3",
 	}
 
-	AddOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
 
-	got := registers["R1"]
+	got := pipeline.register("R1")
 	if got != want {
 		t.Errorf("ADDI = %d, want %d", got, want)
 	}
@@ -113,17 +165,19 @@ func TestBeq(t *testing.T) {
 		Labels: labels,
 	}
 
-	registers = make(map[string]int8)
-	registers["R1"] = 3
-	registers["R2"] = 3
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", 3)
+	pipeline.Regs.Set("R2", 3)
 
 	instruction := &Instruction{
-		Op1: "R1",
-		Op2: "R2",
-		Op3: "loop",
+		Opcode: BEQ,
+		Op1:    "R1",
+		Op2:    "R2",
+		Op3:    "loop",
 	}
 
-	BeqOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	BranchOperation(instruction, pipeline)
 
 	got := pipeline.PC
 	if got != want {
@@ -132,77 +186,90 @@ func TestBeq(t *testing.T) {
 }
 
 func TestSubi(t *testing.T) {
-    var want int8 = 1
+    var want int64 = 1
 
 	pipeline := &PipelineNOOP{
 		Labels: make(map[string]int),
 	}
 
-	registers = make(map[string]int8)
-	registers["R9"] = 2
-	registers["R10"] = 0
-	registers["R11"] = 1
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R9", 2)
+	pipeline.Regs.Set("R10", 0)
+	pipeline.Regs.Set("R11", 1)
 
 	instruction := &Instruction{
-		Op1: "R9",
-		Op2: "R10",
-		Op3: "R11",
+		Opcode: SUBI,
+		Op1:    "R9",
+		Op2:    "R10",
+		Op3:    "R11",
 	}
 
-	SubiOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
 
-	got := registers["R10"]
+	got := pipeline.register("R10")
 	if got != want {
 		t.Errorf("SUBI = %d, want %d", got, want)
 	}
 }
 
 func TestSubiLabeled(t *testing.T) {
-    var want int8 = 2
+    var want int64 = 2
+
+	mem := NewMemory(16, binary.BigEndian)
+	mem.Store(3, wordSize, 2)
 
 	pipeline := &PipelineNOOP{
-		Labels: map[string]int{
-			"two": 10,
+		Data: map[string]int{
+			"two": 3,
 		},
+		Mem: mem,
 	}
 
-	registers = make(map[string]int8)
-	registers["R9"] = 4
-	registers["R10"] = 0
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R9", 4)
+	pipeline.Regs.Set("R10", 0)
 
 	instruction := &Instruction{
-		Op1: "R9",
-		Op2: "R10",
-		Op3: "two",
+		Opcode: SUBI,
+		Op1:    "R9",
+		Op2:    "R10",
+		Op3:    "two",
 	}
 
-	SubiOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
 
-	got := registers["R10"]
+	got := pipeline.register("R10")
 	if got != want {
 		t.Errorf("SUBI = %d, want %d", got, want)
 	}
 }
 
 func TestSub(t *testing.T) {
-    var want int8 = 2
+    var want int64 = 2
 
 	pipeline := &PipelineNOOP{}
 
-	registers = make(map[string]int8)
-	registers["R1"] = 0
-	registers["R2"] = 3
-	registers["R3"] = 1
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", 0)
+	pipeline.Regs.Set("R2", 3)
+	pipeline.Regs.Set("R3", 1)
 
 	instruction := &Instruction{
-		Op1: "R1",
-		Op2: "R2",
-		Op3: "R3",
+		Opcode: SUB,
+		Op1:    "R1",
+		Op2:    "R2",
+		Op3:    "R3",
 	}
 
-	SubOperation(instruction, pipeline)
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
 
-	got := registers["R1"]
+	got := pipeline.register("R1")
 	if got != want {
 		t.Errorf("ADDI = %d, want %d", got, want)
 	}
@@ -229,3 +296,417 @@ func TestJ(t *testing.T) {
     }
 
 }
+
+func TestMemoryAccessLoad(t *testing.T) {
+	var want int64 = -7
+
+	mem := NewMemory(16, binary.BigEndian)
+	mem.Store(5, wordSize, -7)
+	pipeline := &PipelineNOOP{Mem: mem}
+
+	instruction := &Instruction{
+		MemRead: true,
+		Address: 5,
+	}
+
+	MemoryAccessOperation(instruction, pipeline)
+
+	got := instruction.MemValue
+	if got != want {
+		t.Errorf("Load = %d, want %d", got, want)
+	}
+}
+
+func TestMemoryAccessStore(t *testing.T) {
+	var want = 9
+
+	pipeline := &PipelineNOOP{Mem: NewMemory(16, binary.BigEndian)}
+
+	instruction := &Instruction{
+		MemWrite: true,
+		Address:  2,
+		MemValue: 9,
+	}
+
+	MemoryAccessOperation(instruction, pipeline)
+
+	got, _ := pipeline.Mem.Load(2, wordSize)
+	if got != want {
+		t.Errorf("Store = %d, want %d", got, want)
+	}
+}
+
+func TestMemoryAccessOutOfBounds(t *testing.T) {
+	pipeline := &PipelineNOOP{Mem: NewMemory(16, binary.BigEndian)}
+
+	instruction := &Instruction{
+		MemRead: true,
+		Address: 16,
+		Valid:   true,
+	}
+
+	err := MemoryAccessOperation(instruction, pipeline)
+	if err == nil || instruction.Valid {
+		t.Errorf("Load out of bounds should fail")
+	}
+}
+
+func TestLw(t *testing.T) {
+	var want int64 = 7
+
+	mem := NewMemory(16, binary.BigEndian)
+	mem.Store(6, wordSize, 7)
+	pipeline := &PipelineNOOP{
+		Data: map[string]int{
+			"array": 4,
+		},
+		Mem: mem,
+	}
+
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", 0)
+	pipeline.Regs.Set("R2", 2)
+
+	instruction := &Instruction{
+		Opcode: LW,
+		Op1:    "R1",
+		Op2:    "array(R2)",
+	}
+
+	DecodeOperation(instruction, pipeline)
+	LwOperation(instruction, pipeline)
+	MemoryAccessOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
+
+	got := pipeline.register("R1")
+	if got != want {
+		t.Errorf("LW = %d, want %d", got, want)
+	}
+}
+
+func TestSw(t *testing.T) {
+	var want = -3
+
+	pipeline := &PipelineNOOP{Mem: NewMemory(16, binary.BigEndian)}
+
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", -3)
+	pipeline.Regs.Set("R2", 8)
+
+	instruction := &Instruction{
+		Opcode: SW,
+		Op1:    "R1",
+		Op2:    "2(R2)",
+	}
+
+	DecodeOperation(instruction, pipeline)
+	SwOperation(instruction, pipeline)
+	MemoryAccessOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
+
+	got, _ := pipeline.Mem.Load(10, wordSize)
+	if got != want {
+		t.Errorf("SW stored %d, want %d", got, want)
+	}
+}
+
+func TestAddiImmediate(t *testing.T) {
+	var want int64 = -3
+
+	pipeline := &PipelineNOOP{}
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", 2)
+	pipeline.Regs.Set("R5", 100)
+
+	// Immediate 5, not R5
+	instruction := &Instruction{
+		Opcode:    SUBI,
+		Op1:       "R2",
+		Op2:       "R1",
+		Op3:       "5",
+		Imm:       5,
+		Immediate: true,
+	}
+
+	DecodeOperation(instruction, pipeline)
+	ALUOperation(instruction, pipeline)
+	WriteBackOperation(instruction, pipeline)
+
+	got := pipeline.register("R2")
+	if got != want {
+		t.Errorf("SUBI = %d, want %d", got, want)
+	}
+}
+
+func TestImmediateOperations(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		rs     int64
+		imm    int64
+		want   int64
+	}{
+		{ANDI, 0b1100, 0b1010, 0b1000},
+		{ORI, 0b1100, 0b1010, 0b1110},
+		{XORI, 0b1100, 0b1010, 0b0110},
+		{SLTI, -2, 1, 1},
+		{SLTI, 2, 1, 0},
+		{SLTIU, -2, 1, 0}, // 0xFE is not less than 1
+		{SLTIU, 1, -1, 1},
+		{LUI, 0, 0x5, 0x50},
+	}
+
+	for _, tt := range tests {
+		pipeline := &PipelineNOOP{}
+		pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+		pipeline.Regs.Set("R1", tt.rs)
+
+		instruction := &Instruction{
+			Opcode:    tt.opcode,
+			Op1:       "R2",
+			Op2:       "R1",
+			Imm:       tt.imm,
+			Immediate: true,
+		}
+		dest := "R2"
+		if tt.opcode == LUI {
+			instruction.Op2 = ""
+		}
+
+		DecodeOperation(instruction, pipeline)
+		if err := ALUOperation(instruction, pipeline); err != nil {
+			t.Fatal(err)
+		}
+		WriteBackOperation(instruction, pipeline)
+
+		got := pipeline.register(dest)
+		if got != tt.want {
+			t.Errorf("%s %d %d = %d, want %d", tt.opcode, tt.rs, tt.imm, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterOperations(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		rs     int64
+		rt     int64
+		want   int64
+	}{
+		{AND, 0b1100, 0b1010, 0b1000},
+		{OR, 0b1100, 0b1010, 0b1110},
+		{XOR, 0b1100, 0b1010, 0b0110},
+		{NOR, 0b1100, 0b1010, -15}, // 0xF1
+		{SLT, -2, 1, 1},
+		{SLT, 1, -2, 0},
+		{SLTU, -2, 1, 0}, // 0xFE is not less than 1
+		{SLTU, 1, -2, 1},
+		{SLLV, 3, 2, 12},
+		{SLLV, 1, 7, -128},
+		{SRLV, -128, 3, 16},
+		{SRAV, -128, 3, -16},
+		{SRAV, 64, 3, 8},
+	}
+
+	for _, tt := range tests {
+		pipeline := &PipelineNOOP{}
+		pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+		pipeline.Regs.Set("R2", tt.rs)
+		pipeline.Regs.Set("R3", tt.rt)
+
+		instruction := &Instruction{
+			Opcode: tt.opcode,
+			Op1:    "R1",
+			Op2:    "R2",
+			Op3:    "R3",
+		}
+
+		DecodeOperation(instruction, pipeline)
+		if err := ALUOperation(instruction, pipeline); err != nil {
+			t.Fatal(err)
+		}
+		WriteBackOperation(instruction, pipeline)
+
+		got := pipeline.register("R1")
+		if got != tt.want {
+			t.Errorf("%s %d %d = %d, want %d", tt.opcode, tt.rs, tt.rt, got, tt.want)
+		}
+	}
+}
+
+func TestShiftOperations(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		width  int
+		rt     int64
+		shamt  int64
+		want   int64
+	}{
+		{SLL, 8, 3, 4, 48},
+		{SLL, 8, 3, 6, -64}, // 0xC0
+		{SRL, 8, -1, 4, 15},
+		{SRA, 8, -1, 4, -1},
+		{SRL, 32, -16, 28, 15},
+		{SRA, 32, -16, 2, -4},
+	}
+
+	for _, tt := range tests {
+		pipeline := &PipelineNOOP{}
+		pipeline.Regs, _ = NewRegisterFile(numRegisters, tt.width)
+		pipeline.Regs.Set("R2", tt.rt)
+
+		instruction := &Instruction{
+			Opcode:    tt.opcode,
+			Op1:       "R1",
+			Op2:       "R2",
+			Imm:       tt.shamt,
+			Immediate: true,
+		}
+
+		DecodeOperation(instruction, pipeline)
+		if err := ALUOperation(instruction, pipeline); err != nil {
+			t.Fatal(err)
+		}
+		WriteBackOperation(instruction, pipeline)
+
+		got := pipeline.register("R1")
+		if got != tt.want {
+			t.Errorf("%s %d bits %d %d = %d, want %d", tt.opcode, tt.width, tt.rt, tt.shamt, got, tt.want)
+		}
+	}
+}
+
+func TestMulDivOperations(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		rs     int64
+		rt     int64
+		hi     int64
+		lo     int64
+	}{
+		{MULT, 100, 3, 1, 44}, // 0x012C
+		{MULT, -2, 3, -1, -6},
+		{MULTU, -2, 3, 2, -6}, // 0xFE * 3 = 0x02FA
+		{DIV, 7, 2, 1, 3},
+		{DIV, -7, 2, -1, -3},
+		{DIVU, -7, 2, 1, 124}, // 0xF9 = 249
+	}
+
+	for _, tt := range tests {
+		pipeline := &PipelineNOOP{}
+		pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+		pipeline.Regs.Set("R1", tt.rs)
+		pipeline.Regs.Set("R2", tt.rt)
+
+		instruction := &Instruction{
+			Opcode: tt.opcode,
+			Op1:    "R1",
+			Op2:    "R2",
+		}
+
+		DecodeOperation(instruction, pipeline)
+		if err := MulDivOperation(instruction, pipeline); err != nil {
+			t.Fatal(err)
+		}
+		WriteHiLoOperation(instruction, pipeline)
+
+		hi, lo := pipeline.register(HI), pipeline.register(LO)
+		if hi != tt.hi || lo != tt.lo {
+			t.Errorf("%s %d %d: HI = %d, LO = %d, want %d and %d", tt.opcode, tt.rs, tt.rt, hi, lo, tt.hi, tt.lo)
+		}
+	}
+}
+
+func TestDivByZero(t *testing.T) {
+	pipeline := &PipelineNOOP{}
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R1", 7)
+
+	instruction := &Instruction{Opcode: DIV, Op1: "R1", Op2: "R2"}
+	DecodeOperation(instruction, pipeline)
+	if err := MulDivOperation(instruction, pipeline); err == nil {
+		t.Error("Expected division by zero error")
+	}
+	if instruction.HiLoWrite {
+		t.Error("Division by zero wrote HI and LO")
+	}
+}
+
+func TestMoveFromOperation(t *testing.T) {
+	pipeline := &PipelineNOOP{}
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set(HI, 1)
+	pipeline.Regs.Set(LO, 44)
+
+	for opcode, want := range map[Opcode]int64{MFHI: 1, MFLO: 44} {
+		instruction := &Instruction{Opcode: opcode, Op1: "R3"}
+		if err := MoveFromOperation(instruction, pipeline); err != nil {
+			t.Fatal(err)
+		}
+		WriteBackOperation(instruction, pipeline)
+
+		if got := pipeline.register("R3"); got != want {
+			t.Errorf("%s R3 = %d, want %d", opcode, got, want)
+		}
+	}
+}
+
+func TestBranchOperations(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		rs     int64
+		rt     int64
+		taken  bool
+	}{
+		{BNE, 3, 3, false},
+		{BNE, 3, 4, true},
+		{BLEZ, 0, 0, true},
+		{BLEZ, 1, 0, false},
+		{BGTZ, 1, 0, true},
+		{BGTZ, 0, 0, false},
+		{BLTZ, -1, 0, true},
+		{BLTZ, 0, 0, false},
+		{BGEZ, 0, 0, true},
+		{BGEZ, -1, 0, false},
+	}
+
+	for _, tt := range tests {
+		pipeline := &PipelineNOOP{Labels: map[string]int{"loop": 10}}
+		pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+		pipeline.Regs.Set("R1", tt.rs)
+		pipeline.Regs.Set("R2", tt.rt)
+
+		instruction := &Instruction{Opcode: tt.opcode, Op1: "R1", Op2: "loop"}
+		if tt.opcode == BNE {
+			instruction.Op2, instruction.Op3 = "R2", "loop"
+		}
+
+		DecodeOperation(instruction, pipeline)
+		if err := BranchOperation(instruction, pipeline); err != nil {
+			t.Fatal(err)
+		}
+
+		if taken := pipeline.PC == 10; taken != tt.taken {
+			t.Errorf("%s %d %d taken = %v, want %v", tt.opcode, tt.rs, tt.rt, taken, tt.taken)
+		}
+	}
+}
+
+func TestJalr(t *testing.T) {
+	pipeline := &PipelineNOOP{}
+	pipeline.Regs, _ = NewRegisterFile(numRegisters, 8)
+	pipeline.Regs.Set("R8", 200) // -56 in 8 bits, jumps are unsigned
+
+	instruction := &Instruction{Opcode: JALR, Op1: "R8", PC: 4}
+	DecodeOperation(instruction, pipeline)
+	if err := JrOperation(instruction, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	WriteBackOperation(instruction, pipeline)
+
+	if pipeline.PC != 200 {
+		t.Errorf("JALR jumped to %d, want 200", pipeline.PC)
+	}
+	if ra := pipeline.register("R31"); ra != 5 {
+		t.Errorf("JALR linked %d, want 5", ra)
+	}
+}
@@ -1,46 +1,72 @@
 package main
 
 import (
-	"log"
-	"os"
-	"strings"
+	"sync"
 )
 
 type Pipeline interface {
 	Read(int) string
 	Label(string) (int, bool)
+	Address(string) (int, bool)
+	Memory() *Memory
+	Registers() *RegisterFile
+	Symbols() []Symbol
 	JumpTo(int)
-	Broadcast(rune)
+	Tick()
 	Stages() []*Stage
 }
 
 type PipelineFile struct {
-	Lines  []string
-	PC     int
-	Labels map[string]int // Label: PC
-	In     chan int
-	Out    chan *Instruction
-	s      []*Stage
+	Program    *Program
+	PC         int            // Next PC to be fetched
+	Labels     map[string]int // Label: PC
+	Data       map[string]int // Label: address
+	Mem        *Memory
+	Regs       *RegisterFile
+	Forwarding ForwardingUnit
+	Predictor  Predictor
+	MulDiv     *MulDivUnit
+	Calls      CallStack
+	Prediction BranchStats
+	Timeline   Timeline
+	Cycles     int
+	Retired    int // Instructions that went through write back
+	Halted     bool
+	s          []*Stage
+	mu         sync.Mutex
+	jumped     bool
+	target     int
+	mispredict bool
+	redirectPC int
+	halting    bool
 }
 
-func NewPipeline(filename string) *PipelineFile {
-	b, err := os.ReadFile(filename)
+// Fails with Diagnostics when the program is invalid
+func NewPipeline(filename string, mem *Memory, regs *RegisterFile, fwd ForwardingUnit, predictor Predictor) (*PipelineFile, error) {
+	program, err := ReadProgram(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if err := program.Load(mem); err != nil {
+		return nil, err
+	}
+	mulDiv, err := NewMulDivUnit(mulDivLatency, regs.Width())
+	if err != nil {
+		return nil, err
 	}
-
-	// For some reason, bytes to string cast cause an extra '\n'
-	content, _ := strings.CutSuffix(string(b), "\n")
-	lines := strings.Split(content, "\n")
 
 	pipeline := &PipelineFile{
-		Lines: lines,
-		PC:    0,
-		In:    make(chan int),
+		Program:    program,
+		PC:         1,
+		Labels:     program.Labels,
+		Data:       program.Data,
+		Mem:        mem,
+		Regs:       regs,
+		Forwarding: fwd,
+		Predictor:  predictor,
+		MulDiv:     mulDiv,
 	}
 
-	pipeline.ParseFile()
-
 	pipeline.s = []*Stage{
 		NewStage("Instruction fetch", "fet"),
 		NewStage("Decode instruction", "dec"),
@@ -49,255 +75,369 @@ func NewPipeline(filename string) *PipelineFile {
 		NewStage("Write back", "wrb"),
 	}
 
-	decodeChan := pipeline.instructionFetch(pipeline.In)
-	executeChan := pipeline.decodeInstruction(decodeChan)
-	memAccessChan := pipeline.executeAddCalc(executeChan)
-	writeBackChan := pipeline.memoryAccess(memAccessChan)
-	pipeline.Out = pipeline.writeBack(writeBackChan)
+	return pipeline, nil
+}
 
-	return pipeline
+func (p *PipelineFile) Read(pc int) string {
+	i, ok := p.Program.Fetch(pc)
+	if !ok {
+		return ""
+	}
+	return i.Raw
 }
 
-func (p *PipelineFile) ParseFile() {
-	labels := make(map[string]int)
+func (p *PipelineFile) Label(name string) (int, bool) {
+	pc, ok := p.Labels[name]
+	return pc, ok
+}
 
-	for i, line := range p.Lines {
-		lineParts := strings.Split(line, " ")
-		key := lineParts[0]
+func (p *PipelineFile) Address(name string) (int, bool) {
+	addr, ok := p.Data[name]
+	return addr, ok
+}
 
-		if !IsOpcode(key) {
-			labels[key] = i + 1
-			Debug("Parsed [%s: %d] constant\n", key, labels[key])
-		}
-	}
+func (p *PipelineFile) Memory() *Memory {
+	return p.Mem
+}
 
-	p.Labels = labels
+func (p *PipelineFile) Registers() *RegisterFile {
+	return p.Regs
 }
 
-func (p *PipelineFile) Start() {
-	go func() {
-		for o := range p.Out {
-			Info("Instruction completed: %v\n", o)
-		}
-	}()
+func (p *PipelineFile) Symbols() []Symbol {
+	return p.Program.Symbols()
+}
 
-	go func() {
-		for p.PC != len(p.Lines) {
-			p.PC++
-			p.In <- p.PC
-			Debug("Send instruction from PC %d\n", p.PC)
+// Called by the branch operations in exe, when the branch is taken
+func (p *PipelineFile) JumpTo(pc int) {
+	p.jumped = true
+	p.target = pc
+}
+
+// Branches are resolved in exe, when fet and dec already hold the
+// instructions fetched following the prediction. On a misprediction, fetch
+// is redirected on the next clock, flushing them.
+func (p *PipelineFile) resolveBranch(i *Instruction) {
+	i.Taken = p.jumped
+	p.jumped = false
+
+	correct := i.Taken == i.Predicted
+	if i.IsConditional() {
+		p.Predictor.Update(i.PC, i.Target, i.Taken)
+		p.Prediction.Branches++
+		if !correct {
+			p.Prediction.Mispredicted++
 		}
-		Info("All instructions sended")
-		close(p.In)
+	} else {
+		p.Prediction.Jumps++
+	}
+	events <- predictionMsg{stats: p.Prediction}
+	if correct {
+		Debug("Branch at PC %d correctly predicted\n", i.PC)
+		return
+	}
 
-		Info("All instructions executed")
-	}()
+	p.mispredict = true
+	p.redirectPC = i.PC + 1
+	if i.Taken {
+		p.redirectPC = i.Target
+	}
 }
 
-func (p *PipelineFile) Read(num int) string {
-	if num > len(p.Lines) {
-		return ""
+// Redirect fetch to the right path of a mispredicted branch
+func (p *PipelineFile) redirect() bool {
+	if !p.mispredict {
+		return false
 	}
-	return p.Lines[num-1]
+	Info("Branch mispredicted, fetching PC %d and flushing %s and %s\n", p.redirectPC, p.s[0].Nickname, p.s[1].Nickname)
+	p.PC = p.redirectPC
+	p.mispredict = false
+	return true
 }
 
-func (p *PipelineFile) Label(name string) (int, bool) {
-	pc, ok := p.Labels[name]
-	return pc, ok
+// One clock cycle. Stalls and flushes are decided on what the stages did in
+// the previous cycle, then all the pipeline registers (IF/ID, ID/EX, EX/MEM
+// and MEM/WB) latch at once and each stage works on its new instruction.
+// Stages run from the last to the first, as registers are written in the
+// first half of the cycle.
+func (p *PipelineFile) Tick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Halted {
+		return
+	}
+	p.Cycles++
+	Debug("Clock %d\n", p.Cycles)
+
+	fet, dec, exe, mem, wrb := p.s[0], p.s[1], p.s[2], p.s[3], p.s[4]
+
+	// After a mispredicted branch, fetch and decode drop the instructions
+	// from the wrong path. On a hazard, they keep their instructions while a
+	// bubble is sent to execute in place of the decoded one. Otherwise the
+	// decoded instruction takes the bypassed operands with it. While the
+	// multiply and divide unit is busy, execute keeps its instruction too and
+	// the bubble goes to mem instead.
+	flush := p.redirect()
+	busy := p.MulDiv.Busy()
+	stall := busy
+	var forwards []Forward
+	if busy {
+		Info("Stall: %v holds %s in the multiply and divide unit\n", exe.CurrInstruction, exe.Nickname)
+	} else if !flush && !p.halting {
+		var hazard Hazard
+		hazard, stall = DetectHazard(dec.CurrInstruction, p.s[2:4], p.Forwarding, p)
+		if stall {
+			Info("Stall: %v waits for %s from %v in %s\n", dec.CurrInstruction, hazard.Register, hazard.Producer, hazard.Stage)
+		} else {
+			forwards = p.Forwarding.Resolve(dec.CurrInstruction, p.s[2:4], p)
+		}
+	}
+
+	idex := dec.CurrInstruction
+	switch {
+	case busy:
+		idex = exe.CurrInstruction
+	case idex == nil:
+	case p.halting:
+		idex = nil
+	case flush:
+		// Goes on as a bubble, so it is still shown on the next stages
+		Info("Flushed %v\n", idex)
+		idex.Flushed = true
+		p.Prediction.Flushed++
+		events <- predictionMsg{stats: p.Prediction}
+	case stall:
+		idex = NewBubble()
+	default:
+		DecodeOperation(idex, p)
+		ForwardOperation(idex, forwards)
+	}
+
+	ifid := fet.CurrInstruction
+	switch {
+	case ifid == nil || stall:
+	case p.halting:
+		ifid = nil
+	case flush:
+		Info("Flushed PC %d\n", ifid.PC)
+		p.Prediction.Flushed++
+		events <- predictionMsg{stats: p.Prediction}
+		ifid = nil
+	}
+
+	exmem := exe.CurrInstruction
+	if busy {
+		exmem = NewBubble()
+	}
+
+	wrb.CurrInstruction = mem.CurrInstruction
+	mem.CurrInstruction = exmem
+	exe.CurrInstruction = idex
+	if !stall {
+		dec.CurrInstruction = ifid
+		fet.CurrInstruction = nil
+	}
+
+	p.writeBack()
+	p.memoryAccess()
+	p.executeAddCalc(busy)
+	if !stall {
+		p.decodeInstruction()
+		p.instructionFetch()
+	}
+	if !p.Halted && p.drained() {
+		// Fetch ran past the last instruction without finding a halt
+		p.Halted = true
+		Info("Program ended without halt after %d cycles\n", p.Cycles)
+		events <- quitMsg{}
+	}
+
+	for position, stage := range p.s {
+		events <- stageToggledMsg{position: position, value: stage.value()}
+	}
+	if stall {
+		events <- stallMsg{position: 1}
+	}
+	events <- timelineMsg{cycle: p.Cycles, cells: p.Timeline.Record(p.Cycles, p.s)}
+	events <- tickedMsg{cycles: p.Cycles}
 }
 
-func (p *PipelineFile) JumpTo(pc int) {
-	p.PC = pc
+// Calls and returns are tracked once written back, so $sp holds the value
+// the call was made with
+func (p *PipelineFile) trackCall(i *Instruction) {
+	if !i.Taken {
+		return
+	}
+	switch i.Opcode {
+	case JAL, JALR:
+		sp, _ := p.Regs.Get(stackPointer)
+		name, _ := p.Program.LabelAt(i.Target)
+		f := Frame{
+			Caller: i.PC,
+			Target: i.Target,
+			Name:   name,
+			Return: i.PC + 1,
+			SP:     int(NewALU(p.Regs.Width()).unsigned(sp)),
+		}
+		p.Calls.Call(f)
+		Info("Call to %v\n", f)
+	case JR:
+		if !p.Calls.Return(i.Target) {
+			return
+		}
+		Info("Return to PC %d\n", i.Target)
+	default:
+		return
+	}
+	events <- callStackMsg{frames: p.Calls.Frames()}
 }
 
-func (p *PipelineFile) Broadcast(v rune) {
+// Nothing left to fetch and every stage holds a bubble, or nothing at all
+func (p *PipelineFile) drained() bool {
 	for _, stage := range p.s {
-		if stage.IsActive {
-			stage.UserChan <- v
+		if i := stage.CurrInstruction; i != nil && !i.IsBubble() {
+			return false
 		}
 	}
+	return true
 }
 
-func (p PipelineFile) Stages() []*Stage {
+func (p *PipelineFile) Stages() []*Stage {
 	return p.s
 }
 
-// in Program counter (PC)
-func (p *PipelineFile) instructionFetch(in chan int) chan string {
+// Fetch reads the PC only after the previous instruction is latched, so a
+// mispredicted branch can redirect it
+func (p *PipelineFile) instructionFetch() {
 	s := p.s[0]
-	out := make(chan string)
-	go func() {
-		Debug("%s goroutine started and is waiting for messages\n", s.Name)
-		for pc := range in {
-			Debug("Instruction fetch recieved PC %d\n", pc)
-			s.CurrPC = pc
-			s.IsActive = true
-			instruction := p.Read(pc)
-
-			events <- stageToggledMsg{
-				position: 0,
-				value:    pc,
-			}
-
-			<-s.UserChan
-			out <- instruction
-			s.IsActive = false
-		}
-		Debug("%s will not recieve anything else\n", s.Name)
-		close(out)
-	}()
+	pc := p.PC
+	instruction, ok := p.Program.Fetch(pc)
+	if p.halting || !ok {
+		// Nothing left, but a mispredicted branch in flight may jump back
+		s.CurrPC = 0
+		return
+	}
 
-	return out
+	Debug("Instruction fetch recieved PC %d\n", pc)
+	s.CurrPC = pc
+
+	// Branch targets are resolved by the assembler, so the predictor is
+	// consulted before the branch is decoded. j and jal are always taken to
+	// their label. Register jumps are always fetched as not taken, their
+	// target is only known in execute.
+	p.PC++
+	switch {
+	case instruction.IsConditional():
+		instruction.Predicted = p.Predictor.Predict(pc, instruction.Target)
+	case instruction.IsBranch() && !instruction.IsRegisterJump():
+		instruction.Predicted = true
+	}
+	if instruction.Predicted {
+		Debug("Predicted branch at PC %d taken to %d\n", pc, instruction.Target)
+		p.PC = instruction.Target
+	}
+	s.CurrInstruction = instruction
 }
 
-// in Raw instrucion line channel
-func (p *PipelineFile) decodeInstruction(in chan string) chan *Instruction {
-	s := p.s[1]
-	out := make(chan *Instruction)
-	go func() {
-		Debug("%s goroutine started and is waiting for messages\n", s.Name)
-		for raw := range in {
-			Debug("Decode instruction recieved instruction %s\n", raw)
-			instruction := parseInstruction(raw)
-			s.CurrInstruction = instruction
-			s.IsActive = true
-
-			events <- stageToggledMsg{
-				position: 1,
-				value:    instruction,
-			}
-
-			<-s.UserChan
-			out <- instruction
-			s.CurrInstruction = nil
-			s.IsActive = false
-		}
-		Debug("%s will not recieve anything else\n", s.Name)
-		close(out)
-	}()
-	return out
+// Instructions are already assembled, decode only reads their registers
+// when handing them to execute, see Tick
+func (p *PipelineFile) decodeInstruction() {
+	if i := p.s[1].CurrInstruction; i != nil {
+		Debug("Decode instruction recieved instruction %s\n", i)
+	}
 }
 
-func parseInstruction(line string) *Instruction {
-	parts := strings.Split(line, " ")
-
-	padding := 0
-	if !IsOpcode(parts[0]) {
-		// Skip label from parse
-		padding = 1
+// A busy execute stage is still working on the multiplication or division
+// it received on a previous clock
+func (p *PipelineFile) executeAddCalc(busy bool) {
+	instruction := p.s[2].CurrInstruction
+	if instruction == nil || instruction.IsBubble() {
+		return
 	}
 
-	i := &Instruction{
-		Opcode: Opcode(parts[0+padding]),
+	if !busy {
+		Debug("Execute Address Calculation recieved instruction %v\n", instruction)
+		if err := p.execute(instruction); err != nil {
+			Error("%s: %v\n", p.Program.Where(instruction), err)
+		}
+		if instruction.IsBranch() {
+			p.resolveBranch(instruction)
+		}
+		if !instruction.HiLoWrite {
+			return
+		}
+		p.MulDiv.Start(instruction)
 	}
 
-	if len(parts) > 1+padding {
-		i.Op1 = parts[1+padding]
-	}
-	if len(parts) > 2+padding {
-		i.Op2 = parts[2+padding]
-	}
-	if len(parts) > 3+padding {
-		i.Op3 = parts[3+padding]
+	if done, ok := p.MulDiv.Clock(); ok {
+		Debug("Multiply and divide unit completed %v\n", done)
+		if err := WriteHiLoOperation(done, p); err != nil {
+			Error("%s: %v\n", p.Program.Where(done), err)
+		}
 	}
-
-	return i
 }
 
-// in Decoded instruction
-func (p *PipelineFile) executeAddCalc(in chan *Instruction) chan *Instruction {
-	s := p.s[2]
-	out := make(chan *Instruction)
-	go func() {
-		Debug("%s goroutine started and is waiting for messages\n", s.Name)
-		for instruction := range in {
-			Debug("Execute Address Calculation recieved instruction %v\n", instruction)
-			s.CurrInstruction = instruction
-			s.IsActive = true
-
-			switch instruction.Opcode {
-			case HALT:
-				Debug("HALT!\n")
-				events <- quitMsg{}
-			case ADDI:
-				AddiOperation(instruction, p)
-			case ADD:
-				AddOperation(instruction, p)
-			case BEQ:
-				BeqOperation(instruction, p)
-			case SUBI:
-				SubiOperation(instruction, p)
-			case SUB:
-				SubOperation(instruction, p)
-			case J:
-				JOperation(instruction, p)
-			}
-
-			events <- stageToggledMsg{
-				position: 2,
-				value:    instruction,
-			}
-
-			<-s.UserChan
-			out <- instruction
-			s.CurrInstruction = nil
-			s.IsActive = false
+func (p *PipelineFile) execute(i *Instruction) error {
+	switch i.Opcode {
+	case HALT:
+		// Younger instructions are dropped, the older ones still finish
+		Debug("HALT!\n")
+		p.halting = true
+	case BEQ, BNE, BLEZ, BGTZ, BLTZ, BGEZ:
+		return BranchOperation(i, p)
+	case J, JAL:
+		return JOperation(i, p)
+	case JR, JALR:
+		return JrOperation(i, p)
+	case LW:
+		return LwOperation(i, p)
+	case SW:
+		return SwOperation(i, p)
+	case MULT, MULTU, DIV, DIVU:
+		return MulDivOperation(i, p)
+	case MFHI, MFLO:
+		return MoveFromOperation(i, p)
+	default:
+		if _, ok := aluControls[i.Opcode]; ok {
+			return ALUOperation(i, p)
 		}
-		Debug("%s will not recieve anything else\n", s.Name)
-		close(out)
-	}()
-	return out
+	}
+	return nil
 }
 
-// in Instruction after execution complete channel
-func (p *PipelineFile) memoryAccess(in chan *Instruction) chan *Instruction {
-	s := p.s[3]
-	out := make(chan *Instruction)
-	go func() {
-		Debug("%s goroutine started and is waiting for messages\n", s.Name)
-		for instruction := range in {
-			s.CurrInstruction = instruction
-			s.IsActive = true
-
-			events <- stageToggledMsg{
-				position: 3,
-				value:    instruction,
-			}
-
-			<-s.UserChan
-			out <- instruction
-			s.CurrInstruction = nil
-			s.IsActive = false
-		}
-		Debug("%s will not recieve anything else\n", s.Name)
-		close(out)
-	}()
-	return out
+func (p *PipelineFile) memoryAccess() {
+	instruction := p.s[3].CurrInstruction
+	if instruction == nil || instruction.IsBubble() {
+		return
+	}
+
+	if err := MemoryAccessOperation(instruction, p); err != nil {
+		Error("%s: %v\n", p.Program.Where(instruction), err)
+	}
 }
 
-// in Instruction after save
-func (p *PipelineFile) writeBack(in chan *Instruction) chan *Instruction {
-	s := p.s[4]
-	out := make(chan *Instruction)
-	go func() {
-		Debug("%s goroutine started and is waiting for messages\n", s.Name)
-		for instruction := range in {
-			Debug("Write Back recieved instruction %v\n", instruction)
-			s.CurrInstruction = instruction
-			s.IsActive = true
-
-			events <- stageToggledMsg{
-				position: 4,
-				value:    instruction,
-			}
-
-			<-s.UserChan
-			out <- instruction
-			s.CurrInstruction = nil
-			s.IsActive = false
-		}
-		Debug("%s will not recieve anything else\n", s.Name)
-		close(out)
-	}()
-	return out
+func (p *PipelineFile) writeBack() {
+	instruction := p.s[4].CurrInstruction
+	switch {
+	case instruction == nil || instruction.Bubble:
+		return
+	case instruction.Flushed:
+		Info("Instruction flushed: %v\n", instruction)
+		return
+	}
+
+	Debug("Write Back recieved instruction %v\n", instruction)
+	if err := WriteBackOperation(instruction, p); err != nil {
+		Error("%s: %v\n", p.Program.Where(instruction), err)
+	}
+	p.Retired++
+	Info("Instruction completed: %v\n", instruction)
+	p.trackCall(instruction)
+
+	if instruction.Opcode == HALT {
+		p.Halted = true
+		Info("Halted after %d cycles\n", p.Cycles)
+		events <- quitMsg{}
+	}
 }
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestPipeline(t *testing.T, program []string, fwd ForwardingUnit, predictor string) *PipelineFile {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "program.txt")
+	if err := os.WriteFile(filename, []byte(strings.Join(program, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return loadTestPipeline(t, filename, fwd, predictor)
+}
+
+// Pipeline running the program in filename, assembly or machine code
+func loadTestPipeline(t *testing.T, filename string, fwd ForwardingUnit, predictor string) *PipelineFile {
+	t.Helper()
+
+	p, err := NewPredictor(predictor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs, err := NewRegisterFile(numRegisters, registerWidth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs.Set(stackPointer, stackTop(64))
+	pipeline, err := NewPipeline(filename, NewMemory(64, binary.BigEndian), regs, fwd, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pipeline
+}
+
+// Tick until halt, failing if it takes more than limit cycles
+func runUntilHalt(t *testing.T, p *PipelineFile, limit int) {
+	t.Helper()
+	for !p.Halted {
+		if p.Cycles == limit {
+			t.Fatalf("Pipeline did not halt after %d cycles", limit)
+		}
+		p.Tick()
+	}
+}
+
+var fullForwarding = ForwardingUnit{ExToEx: true, MemToEx: true}
+
+var loopProgram = []string{
+	"addi R0 R1 neg1",
+	"addi R0 R2 three",
+	"loop add 2 1 2",
+	"beq 2 0 done",
+	"j loop",
+	"done halt",
+	"neg1 .fill -1",
+	"three .fill 3",
+}
+
+func TestTickStraightLine(t *testing.T) {
+	p := newTestPipeline(t, []string{
+		"addi R0 R1 one",
+		"addi R0 R2 two",
+		"done halt",
+		"one .fill 1",
+		"two .fill 2",
+	}, fullForwarding, "not-taken")
+
+	runUntilHalt(t, p, 50)
+
+	// Three instructions and four cycles to fill the pipeline
+	if p.Cycles != 7 || p.Retired != 3 {
+		t.Errorf("Cycles = %d, retired = %d, want 7 and 3", p.Cycles, p.Retired)
+	}
+}
+
+func TestTickStalls(t *testing.T) {
+	program := []string{
+		"addi R0 R1 one",
+		"add 2 1 1",
+		"done halt",
+		"one .fill 1",
+	}
+
+	tests := []struct {
+		fwd  ForwardingUnit
+		want int
+	}{
+		{ForwardingUnit{}, 9},
+		{ForwardingUnit{MemToEx: true}, 8},
+		{fullForwarding, 7},
+	}
+
+	for _, tt := range tests {
+		p := newTestPipeline(t, program, tt.fwd, "not-taken")
+		runUntilHalt(t, p, 50)
+
+		if p.Cycles != tt.want {
+			t.Errorf("Forwarding %v took %d cycles, want %d", tt.fwd, p.Cycles, tt.want)
+		}
+		if r2, _ := p.Regs.Get("R2"); r2 != 2 {
+			t.Errorf("Forwarding %v R2 = %d, want 2", tt.fwd, r2)
+		}
+	}
+}
+
+func TestTickZeroRegister(t *testing.T) {
+	program := []string{
+		"addi R0 R0 5",
+		"add 2 0 0",
+		"done halt",
+	}
+
+	for _, fwd := range []ForwardingUnit{fullForwarding, {}} {
+		p := newTestPipeline(t, program, fwd, "not-taken")
+		runUntilHalt(t, p, 50)
+
+		// Nothing to wait for nor to bypass
+		if r2, _ := p.Regs.Get("R2"); r2 != 0 || p.Cycles != 7 {
+			t.Errorf("Forwarding %v: R2 = %d after %d cycles, want 0 after 7", fwd, r2, p.Cycles)
+		}
+	}
+}
+
+func TestTickImmediateOrder(t *testing.T) {
+	p := newTestPipeline(t, []string{
+		"addi $t0, $zero, 5",
+		"addi $t1, $t0, 2",
+		"andi $t3, $t1, 3",
+		"slti $t4, $t0, 6",
+		"addi R0 $t5 one", // Source first, with a data label
+		"done halt",
+		"one .fill 1",
+	}, fullForwarding, "not-taken")
+	runUntilHalt(t, p, 50)
+
+	want := map[string]int64{"R0": 0, "R8": 5, "R9": 7, "R11": 3, "R12": 1, "R13": 1}
+	for name, v := range want {
+		if got, _ := p.Regs.Get(name); got != v {
+			t.Errorf("%s = %d, want %d", name, got, v)
+		}
+	}
+}
+
+func TestTickLoadUse(t *testing.T) {
+	p := newTestPipeline(t, []string{
+		"lw R1 one(R0)",
+		"add 2 1 1",
+		"sw R2 4(R0)",
+		"done halt",
+		"one .fill 1",
+	}, fullForwarding, "not-taken")
+
+	runUntilHalt(t, p, 50)
+
+	if p.Cycles != 9 {
+		t.Errorf("Cycles = %d, want 9", p.Cycles)
+	}
+	if got, _ := p.Mem.Load(4, wordSize); got != 2 {
+		t.Errorf("Stored %d, want 2", got)
+	}
+}
+
+func TestTickBranchPrediction(t *testing.T) {
+	tests := []struct {
+		predictor    string
+		want         int
+		mispredicted int
+	}{
+		{"not-taken", 17, 1}, // Only the loop exit
+		{"taken", 19, 2},     // The two beq not taken
+		{"btfn", 17, 1},      // Only the loop exit
+		{"2-bit", 17, 1},     // Weakly not taken until the loop exit
+	}
+
+	for _, tt := range tests {
+		p := newTestPipeline(t, loopProgram, fullForwarding, tt.predictor)
+		runUntilHalt(t, p, 100)
+
+		// Eleven instructions, plus two cycles for each misprediction
+		if p.Cycles != tt.want || p.Retired != 11 {
+			t.Errorf("%s: cycles = %d, retired = %d, want %d and 11", tt.predictor, p.Cycles, p.Retired, tt.want)
+		}
+		if r2, _ := p.Regs.Get("R2"); r2 != 0 {
+			t.Errorf("%s: R2 = %d, want 0", tt.predictor, r2)
+		}
+
+		// Three beq and two j always taken, each misprediction flushing fet
+		// and dec
+		want := BranchStats{Branches: 3, Mispredicted: tt.mispredicted, Jumps: 2, Flushed: 2 * tt.mispredicted}
+		if p.Prediction != want {
+			t.Errorf("%s: prediction = %+v, want %+v", tt.predictor, p.Prediction, want)
+		}
+	}
+}
+
+// Width used by newTestPipeline until the end of the test
+func setWidth(t *testing.T, width int) {
+	previous := registerWidth
+	registerWidth, wordSize = width, width/8
+	t.Cleanup(func() {
+		registerWidth, wordSize = previous, previous/8
+	})
+}
+
+func TestTickWidth(t *testing.T) {
+	program := []string{
+		"addi R0 R1 big",
+		"add 2 1 1",
+		"sw R2 sum(R0)",
+		"done halt",
+		"big .fill 100",
+		"sum .fill 0",
+	}
+
+	tests := []struct {
+		width int
+		want  int64
+	}{
+		{8, -56}, // Wraps around at 127
+		{16, 200},
+		{32, 200},
+	}
+
+	for _, tt := range tests {
+		setWidth(t, tt.width)
+		p := newTestPipeline(t, program, fullForwarding, "not-taken")
+		runUntilHalt(t, p, 50)
+
+		if r2, _ := p.Regs.Get("R2"); r2 != tt.want {
+			t.Errorf("%d bits: R2 = %d, want %d", tt.width, r2, tt.want)
+		}
+		// The second word, after big
+		if got, _ := p.Mem.Load(wordSize, wordSize); int64(got) != tt.want {
+			t.Errorf("%d bits: stored %d, want %d", tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestTickWidth32(t *testing.T) {
+	setWidth(t, 32)
+	p := newTestPipeline(t, []string{
+		"addi R0 R1 big",
+		"add 2 1 1",
+		"done halt",
+		"big .fill 1500000000",
+	}, fullForwarding, "not-taken")
+	runUntilHalt(t, p, 50)
+
+	if r2, _ := p.Regs.Get("R2"); r2 != -1294967296 {
+		t.Errorf("R2 = %d, want -1294967296", r2)
+	}
+}
+
+func TestTickMulDivLatency(t *testing.T) {
+	program := []string{
+		"addi R0 R1 hundred",
+		"addi R0 R2 three",
+		"mult R1 R2",
+		"mflo R3",
+		"mfhi R4",
+		"done halt",
+		"hundred .fill 100",
+		"three .fill 3",
+	}
+
+	// The multiplication holds exe for latency clocks, stalling the ones
+	// behind it for all but the first
+	for _, latency := range []int{1, 4} {
+		p := newTestPipeline(t, program, fullForwarding, "not-taken")
+		p.MulDiv.Latency = latency
+		runUntilHalt(t, p, 50)
+
+		if want := 10 + latency - 1; p.Cycles != want {
+			t.Errorf("Latency %d took %d cycles, want %d", latency, p.Cycles, want)
+		}
+		// 300 is 0x12C, which does not fit 8 bits
+		lo, _ := p.Regs.Get("R3")
+		hi, _ := p.Regs.Get("R4")
+		if lo != 44 || hi != 1 {
+			t.Errorf("Latency %d LO = %d, HI = %d, want 44 and 1", latency, lo, hi)
+		}
+	}
+}
+
+func TestTickCall(t *testing.T) {
+	p := newTestPipeline(t, []string{
+		"addi R0 R4 three",
+		"jal double",
+		"add R5 R2 R0",
+		"done halt",
+		"double add R2 R4 R4",
+		"jr $ra",
+		"three .fill 3",
+	}, fullForwarding, "not-taken")
+
+	runUntilHalt(t, p, 50)
+
+	for name, want := range map[string]int64{"R2": 6, "R5": 6, "R31": 3} {
+		if got, _ := p.Regs.Get(name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestTickBneLoop(t *testing.T) {
+	p := newTestPipeline(t, []string{
+		"addi R0 R1 three",
+		"loop subi R1 R1 1",
+		"addi R2 R2 1",
+		"bne R1 R0 loop",
+		"done halt",
+		"three .fill 3",
+	}, fullForwarding, "2-bit")
+
+	runUntilHalt(t, p, 100)
+
+	if r2, _ := p.Regs.Get("R2"); r2 != 3 {
+		t.Errorf("Loop ran %d times, want 3", r2)
+	}
+}
+
+// fact(n) = n * fact(n - 1), saving $ra and $a0 on the stack
+var factorialProgram = []string{
+	"addi $a0 R0 4",
+	"jal fact",
+	"add $s0 $v0 R0",
+	"done halt",
+	"fact addi $sp $sp -2",
+	"sw $ra 1($sp)",
+	"sw $a0 0($sp)",
+	"addi $v0 R0 1",
+	"subi $t0 $a0 1",
+	"blez $t0 ret",
+	"subi $a0 $a0 1",
+	"jal fact",
+	"lw $a0 0($sp)",
+	"mult $a0 $v0",
+	"mflo $v0",
+	"ret lw $ra 1($sp)",
+	"addi $sp $sp 2",
+	"jr $ra",
+}
+
+func TestTickCallStack(t *testing.T) {
+	p := newTestPipeline(t, factorialProgram, fullForwarding, "not-taken")
+
+	// Deepest call: fact(1), each frame two words below the previous one
+	var deepest []Frame
+	for !p.Halted && p.Cycles < 500 {
+		p.Tick()
+		if frames := p.Calls.Frames(); len(frames) > len(deepest) {
+			deepest = frames
+		}
+	}
+
+	if got, _ := p.Regs.Get("R16"); got != 24 {
+		t.Errorf("fact(4) = %d, want 24", got)
+	}
+	if len(deepest) != 4 {
+		t.Fatalf("Call depth = %d, want 4", len(deepest))
+	}
+	for n, f := range deepest {
+		if want := 63 - 2*n; f.SP != want || f.Name != "fact" {
+			t.Errorf("Frame %d = %s at $sp %d, want fact at %d", n, f, f.SP, want)
+		}
+	}
+	if deepest[0].Return != 3 || deepest[1].Return != 13 {
+		t.Errorf("Return addresses %d and %d, want 3 and 13", deepest[0].Return, deepest[1].Return)
+	}
+	if len(p.Calls.Frames()) != 0 {
+		t.Errorf("Frames left after halt: %v", p.Calls.Frames())
+	}
+}
+
+func TestTickPseudo(t *testing.T) {
+	setWidth(t, 32)
+	p := newTestPipeline(t, []string{
+		"li $t0 0x12345",
+		"li $t1 3",
+		"loop addi $t2 $t2 1",
+		"subi $t1 $t1 1",
+		"bgt $t1 $zero loop",
+		"neg $t3 $t0",
+		"done halt",
+	}, fullForwarding, "not-taken")
+
+	runUntilHalt(t, p, 100)
+
+	for name, want := range map[string]int64{"R8": 0x12345, "R10": 3, "R11": -0x12345} {
+		if got, _ := p.Regs.Get(name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import "fmt"
+
+// Entries of the prediction tables, indexed by the branch PC
+const predictorEntries = 16
+
+// Consulted by fetch for every branch, with the target decoded from its
+// label, and updated by execute once the branch is resolved
+type Predictor interface {
+	Predict(pc, target int) bool
+	Update(pc, target int, taken bool)
+	String() string
+}
+
+var predictors = []string{"not-taken", "taken", "btfn", "1-bit", "2-bit", "btb"}
+
+// Outcome of the predictions of a run. Only conditional branches are
+// predicted, jumps are counted apart. The penalty is the cycles lost by the
+// instructions flushed from the wrong path, jr included
+type BranchStats struct {
+	Branches     int
+	Mispredicted int
+	Jumps        int
+	Flushed      int
+}
+
+// Share of the branches correctly predicted, 0 when there were none
+func (s BranchStats) Accuracy() float64 {
+	if s.Branches == 0 {
+		return 0
+	}
+	return float64(s.Branches-s.Mispredicted) / float64(s.Branches)
+}
+
+func NewPredictor(name string) (Predictor, error) {
+	switch name {
+	case "not-taken":
+		return StaticPredictor{taken: false}, nil
+	case "taken":
+		return StaticPredictor{taken: true}, nil
+	case "btfn":
+		return BTFNPredictor{}, nil
+	case "1-bit":
+		return &OneBitPredictor{}, nil
+	case "2-bit":
+		p := &TwoBitPredictor{}
+		for i := range p.counters {
+			p.counters[i] = weaklyNotTaken
+		}
+		return p, nil
+	case "btb":
+		return &BTBPredictor{}, nil
+	}
+	return nil, fmt.Errorf("Unknown predictor %s, expected one of %v", name, predictors)
+}
+
+// Always the same prediction
+type StaticPredictor struct {
+	taken bool
+}
+
+func (p StaticPredictor) Predict(pc, target int) bool {
+	return p.taken
+}
+
+func (p StaticPredictor) Update(pc, target int, taken bool) {}
+
+func (p StaticPredictor) String() string {
+	if p.taken {
+		return "taken"
+	}
+	return "not-taken"
+}
+
+// Backward taken, forward not taken. Backward branches are usually loops
+type BTFNPredictor struct{}
+
+func (p BTFNPredictor) Predict(pc, target int) bool {
+	return target <= pc
+}
+
+func (p BTFNPredictor) Update(pc, target int, taken bool) {}
+
+func (p BTFNPredictor) String() string {
+	return "btfn"
+}
+
+// Predicts the last outcome of the branch
+type OneBitPredictor struct {
+	taken [predictorEntries]bool
+}
+
+func (p *OneBitPredictor) Predict(pc, target int) bool {
+	return p.taken[pc%predictorEntries]
+}
+
+func (p *OneBitPredictor) Update(pc, target int, taken bool) {
+	p.taken[pc%predictorEntries] = taken
+}
+
+func (p *OneBitPredictor) String() string {
+	return "1-bit"
+}
+
+// Saturating counter states
+const (
+	stronglyNotTaken = iota
+	weaklyNotTaken
+	weaklyTaken
+	stronglyTaken
+)
+
+// Saturating counters, so a single different outcome, like a loop exit, does
+// not change the prediction
+type TwoBitPredictor struct {
+	counters [predictorEntries]int
+}
+
+func (p *TwoBitPredictor) Predict(pc, target int) bool {
+	return p.counters[pc%predictorEntries] >= weaklyTaken
+}
+
+func (p *TwoBitPredictor) Update(pc, target int, taken bool) {
+	c := &p.counters[pc%predictorEntries]
+	if taken && *c < stronglyTaken {
+		*c++
+	}
+	if !taken && *c > stronglyNotTaken {
+		*c--
+	}
+}
+
+func (p *TwoBitPredictor) String() string {
+	return "2-bit"
+}
+
+type btbEntry struct {
+	valid  bool
+	pc     int
+	target int
+}
+
+// Branch target buffer. Branches taken the last time are kept with their
+// target, so a hit predicts taken
+type BTBPredictor struct {
+	entries [predictorEntries]btbEntry
+}
+
+func (p *BTBPredictor) Predict(pc, target int) bool {
+	e := p.entries[pc%predictorEntries]
+	return e.valid && e.pc == pc && e.target == target
+}
+
+func (p *BTBPredictor) Update(pc, target int, taken bool) {
+	e := &p.entries[pc%predictorEntries]
+	if taken {
+		*e = btbEntry{valid: true, pc: pc, target: target}
+	} else if e.pc == pc {
+		e.valid = false
+	}
+}
+
+func (p *BTBPredictor) String() string {
+	return "btb"
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+// Loop branch at PC 8 jumping back to PC 3: taken three times, then exits
+var loopOutcomes = []bool{true, true, true, false, true, true, true, false}
+
+func TestPredictors(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"not-taken", 2},
+		{"taken", 6},
+		{"btfn", 6},
+		{"1-bit", 4},
+		{"2-bit", 5},
+		{"btb", 4},
+	}
+
+	for _, tt := range tests {
+		p, err := NewPredictor(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := 0
+		for _, taken := range loopOutcomes {
+			if p.Predict(8, 3) == taken {
+				got++
+			}
+			p.Update(8, 3, taken)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s predicted %d of %d, want %d", tt.name, got, len(loopOutcomes), tt.want)
+		}
+	}
+}
+
+func TestBTFNForward(t *testing.T) {
+	p, _ := NewPredictor("btfn")
+	if p.Predict(4, 9) {
+		t.Errorf("BTFN should predict forward branch as not taken")
+	}
+}
+
+func TestUnknownPredictor(t *testing.T) {
+	if _, err := NewPredictor("perceptron"); err == nil {
+		t.Errorf("Unknown predictor should fail")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Native instruction a pseudo-instruction expands to. Operands keep the
+// position of the source operand they come from, for the diagnostics
+type native struct {
+	opcode   Opcode
+	operands []Token
+}
+
+// Pseudo-instruction, expanded by the assembler into native ones as MARS and
+// SPIM do. The number of native instructions is known on the first pass, so
+// labels get their PC, while the expansion itself waits for every label to be
+// known.
+type pseudoOp struct {
+	operands int
+	size     func(ops []Token) int
+	expand   func(ops []Token, at Position, p *Program) ([]native, error)
+}
+
+// Register used by the expansions, $at
+const assemblerTemporary = "R1"
+
+var pseudoOps = map[string]pseudoOp{
+	"li":   {2, liSize, expandLi},
+	"la":   {2, fixedSize(1), expandLa},
+	"move": {2, fixedSize(1), expandMove},
+	"nop":  {0, fixedSize(1), expandNop},
+	"not":  {2, fixedSize(1), expandNot},
+	"neg":  {2, fixedSize(1), expandNeg},
+	"b":    {1, fixedSize(1), expandB},
+	"blt":  {3, fixedSize(2), compareBranch(false, BNE)},
+	"bgt":  {3, fixedSize(2), compareBranch(true, BNE)},
+	"ble":  {3, fixedSize(2), compareBranch(true, BEQ)},
+	"bge":  {3, fixedSize(2), compareBranch(false, BEQ)},
+}
+
+func isPseudo(s string) bool {
+	_, ok := pseudoOps[s]
+	return ok
+}
+
+func fixedSize(n int) func([]Token) int {
+	return func([]Token) int { return n }
+}
+
+// Token made up by an expansion, placed at the pseudo-instruction
+func at(text string, pos Position) Token {
+	return Token{Text: text, Pos: pos}
+}
+
+// Value of li, as stored in a register of the word width
+func liValue(tok Token) (int64, error) {
+	v, err := strconv.ParseInt(tok.Text, 0, 64)
+	if err != nil || !fitsWord(int(v)) {
+		return 0, fmt.Errorf("invalid li value %q", tok.Text)
+	}
+	shift := 64 - 8*wordSize
+	return v << shift >> shift, nil
+}
+
+// Values that do not fit the immediate field take lui and ori
+func liSize(ops []Token) int {
+	v, err := liValue(ops[1])
+	if err != nil || (v >= -1<<(immediateBits-1) && v < 1<<(immediateBits-1)) {
+		return 1
+	}
+	return 2
+}
+
+// li $t0 5: addi $t0 R0 5
+// li $t0 0x12345: lui $t0 0x1, ori $t0 $t0 0x2345
+func expandLi(ops []Token, pos Position, p *Program) ([]native, error) {
+	v, err := liValue(ops[1])
+	if err != nil {
+		return nil, err
+	}
+	if liSize(ops) == 1 {
+		return []native{
+			{ADDI, []Token{ops[0], at("R0", pos), at(strconv.FormatInt(v, 10), ops[1].Pos)}},
+		}, nil
+	}
+
+	upper := uint64(v) >> immediateBits & (1<<immediateBits - 1)
+	lower := uint64(v) & (1<<immediateBits - 1)
+	return []native{
+		{LUI, []Token{ops[0], at(strconv.FormatUint(upper, 10), ops[1].Pos)}},
+		{ORI, []Token{ops[0], ops[0], at(strconv.FormatUint(lower, 10), ops[1].Pos)}},
+	}, nil
+}
+
+// la $t0 ten: addi $t0 R0 <address of ten>
+func expandLa(ops []Token, pos Position, p *Program) ([]native, error) {
+	addr, ok := p.Data[ops[1].Text]
+	if !ok {
+		return nil, fmt.Errorf("undefined data label %q", ops[1].Text)
+	}
+	return []native{
+		{ADDI, []Token{ops[0], at("R0", pos), at(strconv.Itoa(addr), ops[1].Pos)}},
+	}, nil
+}
+
+// move $t0 $t1: add $t0 $t1 R0
+func expandMove(ops []Token, pos Position, p *Program) ([]native, error) {
+	return []native{{ADD, []Token{ops[0], ops[1], at("R0", pos)}}}, nil
+}
+
+func expandNop(ops []Token, pos Position, p *Program) ([]native, error) {
+	return []native{{NOOP, nil}}, nil
+}
+
+// not $t0 $t1: nor $t0 $t1 R0
+func expandNot(ops []Token, pos Position, p *Program) ([]native, error) {
+	return []native{{NOR, []Token{ops[0], ops[1], at("R0", pos)}}}, nil
+}
+
+// neg $t0 $t1: sub $t0 R0 $t1
+func expandNeg(ops []Token, pos Position, p *Program) ([]native, error) {
+	return []native{{SUB, []Token{ops[0], at("R0", pos), ops[1]}}}, nil
+}
+
+// b loop: beq R0 R0 loop
+func expandB(ops []Token, pos Position, p *Program) ([]native, error) {
+	return []native{{BEQ, []Token{at("R0", pos), at("R0", pos), ops[0]}}}, nil
+}
+
+// Comparison in $at followed by a branch on it
+//
+//	blt $t0 $t1 loop: slt $at $t0 $t1, bne $at R0 loop
+//	bgt $t0 $t1 loop: slt $at $t1 $t0, bne $at R0 loop
+//	ble $t0 $t1 loop: slt $at $t1 $t0, beq $at R0 loop
+//	bge $t0 $t1 loop: slt $at $t0 $t1, beq $at R0 loop
+func compareBranch(swap bool, branch Opcode) func([]Token, Position, *Program) ([]native, error) {
+	return func(ops []Token, pos Position, p *Program) ([]native, error) {
+		x, y := ops[0], ops[1]
+		if swap {
+			x, y = y, x
+		}
+		return []native{
+			{SLT, []Token{at(assemblerTemporary, pos), x, y}},
+			{branch, []Token{at(assemblerTemporary, pos), at("R0", pos), ops[2]}},
+		}, nil
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MIPS ABI names, indexed by register number
+var abiNames = [...]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+// ABI alias of a register, as $t0 for R8. Empty past R31
+func abiName(n int) string {
+	if n >= len(abiNames) {
+		return ""
+	}
+	return "$" + abiNames[n]
+}
+
+// Register number from R<n>, a bare n, $n or an ABI name like $t0. $s8 is
+// the same as $fp
+func registerNumber(s string) (int, bool) {
+	name, abi := strings.CutPrefix(s, "$")
+	if abi {
+		for n, alias := range abiNames {
+			if name == alias {
+				return n, true
+			}
+		}
+		if name == "s8" {
+			return 30, true
+		}
+	} else {
+		name = strings.TrimPrefix(name, "R")
+	}
+
+	n, err := strconv.Atoi(name)
+	if err != nil || n < 0 || n >= numRegisters {
+		return 0, false
+	}
+	return n, true
+}
+
+// Register operand in its canonical R<n> name
+func parseRegister(s string) (string, bool) {
+	n, ok := registerNumber(s)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("R%d", n), true
+}
+
+// Formats register values are shown in
+var displayFormats = []string{"dec", "hex", "bin"}
+
+// Value as decimal, or as the register bits in hexadecimal or binary
+func formatWord(v int64, width int, format string) string {
+	u := uint64(v) & (1<<width - 1)
+	switch format {
+	case "hex":
+		return fmt.Sprintf("0x%0*X", width/4, u)
+	case "bin":
+		return fmt.Sprintf("0b%0*b", width, u)
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func nextFormat(format string) string {
+	i := slices.Index(displayFormats, format)
+	return displayFormats[(i+1)%len(displayFormats)]
+}
+
+// Register widths supported by the datapath
+var registerWidths = []int{8, 16, 32}
+
+// Special registers written by the multiply and divide unit
+const (
+	HI = "HI"
+	LO = "LO"
+)
+
+// General purpose registers R0..R<count-1>, followed by HI and LO. R0 is
+// hard-wired to zero: writes to it are ignored. Values are kept sign extended
+// to the register width.
+type RegisterFile struct {
+	values []int64
+	width  int
+}
+
+func NewRegisterFile(count, width int) (*RegisterFile, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("Invalid register count %d", count)
+	}
+	if !slices.Contains(registerWidths, width) {
+		return nil, fmt.Errorf("Invalid register width %d, expected one of %v", width, registerWidths)
+	}
+	return &RegisterFile{
+		values: make([]int64, count+2),
+		width:  width,
+	}, nil
+}
+
+// Number of general purpose registers, HI and LO aside
+func (r *RegisterFile) Count() int {
+	return len(r.values) - 2
+}
+
+func (r *RegisterFile) Width() int {
+	return r.width
+}
+
+// Name of register n, as R8
+func (r *RegisterFile) Name(n int) string {
+	return fmt.Sprintf("R%d", n)
+}
+
+func (r *RegisterFile) index(name string) (int, error) {
+	switch name {
+	case HI:
+		return r.Count(), nil
+	case LO:
+		return r.Count() + 1, nil
+	}
+	n, ok := registerNumber(name)
+	if !ok || n >= r.Count() {
+		return 0, fmt.Errorf("Register %s does not exist", name)
+	}
+	return n, nil
+}
+
+// Value truncated to the register width and sign extended
+func (r *RegisterFile) Truncate(v int64) int64 {
+	shift := 64 - r.width
+	return v << shift >> shift
+}
+
+// Value of a register, without going through the ports
+func (r *RegisterFile) Get(name string) (int64, error) {
+	n, err := r.index(name)
+	if err != nil {
+		return 0, err
+	}
+	return r.values[n], nil
+}
+
+// Initial value of a register, without going through the ports
+func (r *RegisterFile) Set(name string, v int64) error {
+	n, err := r.index(name)
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		r.values[n] = r.Truncate(v)
+	}
+	return nil
+}
+
+// Read port, used by decode
+func (r *RegisterFile) Read(name string) (int64, error) {
+	return r.Get(name)
+}
+
+// Write port, used by write back. Returns the value actually stored, which is
+// always 0 for R0
+func (r *RegisterFile) Write(name string, v int64) (int64, error) {
+	n, err := r.index(name)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	r.values[n] = r.Truncate(v)
+	return r.values[n], nil
+}
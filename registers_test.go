@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestRegisterFileZero(t *testing.T) {
+	regs, _ := NewRegisterFile(32, 8)
+
+	v, err := regs.Write("R0", 5)
+	if err != nil || v != 0 {
+		t.Errorf("Write R0 = %d, %v, want 0", v, err)
+	}
+	if v, _ := regs.Get("$zero"); v != 0 {
+		t.Errorf("R0 = %d, want 0", v)
+	}
+}
+
+func TestRegisterFileWidth(t *testing.T) {
+	tests := []struct {
+		width int
+		value int64
+		want  int64
+	}{
+		{8, 127, 127},
+		{8, 128, -128},
+		{8, 300, 44},
+		{16, 300, 300},
+		{16, 40000, -25536},
+		{32, 40000, 40000},
+	}
+
+	for _, tt := range tests {
+		regs, _ := NewRegisterFile(32, tt.width)
+		if got, _ := regs.Write("R1", tt.value); got != tt.want {
+			t.Errorf("%d bits: wrote %d, got %d, want %d", tt.width, tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := NewRegisterFile(32, 12); err == nil {
+		t.Error("Expected error for 12 bits register")
+	}
+}
+
+func TestRegisterFilePorts(t *testing.T) {
+	regs, _ := NewRegisterFile(8, 8)
+
+	if v, _ := regs.Write("R2", 300); v != 44 {
+		t.Errorf("Wrote %d to R2, want 44", v)
+	}
+	if v, _ := regs.Read("R2"); v != 44 {
+		t.Errorf("R2 = %d, want 44", v)
+	}
+	if v, _ := regs.Write("R0", 1); v != 0 {
+		t.Errorf("Wrote %d to R0, want 0", v)
+	}
+	if _, err := regs.Read("R8"); err == nil {
+		t.Error("Expected error reading R8 from 8 registers")
+	}
+}
+
+func TestFormatWord(t *testing.T) {
+	tests := []struct {
+		value  int64
+		width  int
+		format string
+		want   string
+	}{
+		{-1, 8, "dec", "-1"},
+		{-1, 8, "hex", "0xFF"},
+		{-1, 16, "hex", "0xFFFF"},
+		{10, 32, "hex", "0x0000000A"},
+		{5, 8, "bin", "0b00000101"},
+		{-2, 8, "bin", "0b11111110"},
+	}
+
+	for _, tt := range tests {
+		if got := formatWord(tt.value, tt.width, tt.format); got != tt.want {
+			t.Errorf("formatWord(%d, %d, %s) = %s, want %s", tt.value, tt.width, tt.format, got, tt.want)
+		}
+	}
+}
@@ -1,20 +1,30 @@
 package main
 
+import "fmt"
+
 type Stage struct {
 	Name            string
 	Nickname        string
-	UserChan        chan rune
 	CurrInstruction *Instruction
 	CurrPC          int
-	IsActive        bool
 }
 
 func NewStage(name, nc string) *Stage {
 	return &Stage{
 		Name:     name,
 		Nickname: nc,
-		UserChan: make(chan rune),
-		IsActive: false,
 		CurrPC:   0,
 	}
 }
+
+// What is shown for the stage. Fetch is the only one setting the PC, as it
+// does not know the instruction yet, along with its line in the source file
+func (s *Stage) value() any {
+	switch {
+	case s.CurrInstruction == nil:
+		return nil
+	case s.CurrPC != 0:
+		return fmt.Sprintf("PC %d (line %d)", s.CurrPC, s.CurrInstruction.Line)
+	}
+	return s.CurrInstruction
+}
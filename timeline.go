@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Cells of the pipeline diagram besides the stages
+const (
+	stallCell = "*"
+	flushCell = "X"
+)
+
+// Cell of each stage, in pipeline order
+var stageCells = []string{"IF", "ID", "EX", "MEM", "WB"}
+
+// Characters taken by each clock of the diagram
+const timelineCellWidth = 5
+
+// Row of the pipeline diagram: a fetched instruction and its cell on each
+// clock from First on
+type TimelineRow struct {
+	Instruction string
+	PC          int
+	First       int
+	Cells       []string
+}
+
+// Cell a clock adds to a row. Row is the index of the row, len(Rows) for a
+// new one
+type TimelineCell struct {
+	Row         int
+	Instruction string
+	PC          int
+	Cell        string
+}
+
+// Pipeline diagram, a row per fetched instruction and a column per clock.
+// The zero value is an empty diagram
+type Timeline struct {
+	Rows   []TimelineRow
+	Cycles int
+	rows   map[*Instruction]int // Row of each instruction in the pipeline
+	stages map[*Instruction]int // Stage of each on the previous clock
+}
+
+// Records a clock from what the stages hold after it. An instruction in the
+// same stage as on the previous clock is stalled, and one that left before
+// write back, as a flushed or dropped one, is flushed
+func (t *Timeline) Record(cycle int, stages []*Stage) []TimelineCell {
+	if t.rows == nil {
+		t.rows = make(map[*Instruction]int)
+	}
+
+	current := make(map[*Instruction]int)
+	cells := make([]TimelineCell, 0)
+	next := len(t.Rows)
+	// Oldest first, so new rows keep the fetch order
+	for pos := len(stages) - 1; pos >= 0; pos-- {
+		i := stages[pos].CurrInstruction
+		if i == nil || i.IsBubble() {
+			continue
+		}
+		current[i] = pos
+
+		row, ok := t.rows[i]
+		if !ok {
+			row = next
+			t.rows[i] = row
+			next++
+		}
+		cell := stageCells[pos]
+		if prev, ok := t.stages[i]; ok && prev == pos {
+			cell = stallCell
+		}
+		cells = append(cells, TimelineCell{Row: row, Instruction: i.String(), PC: i.PC, Cell: cell})
+	}
+
+	for i, prev := range t.stages {
+		if _, ok := current[i]; ok {
+			continue
+		}
+		if prev < len(stages)-1 {
+			cells = append(cells, TimelineCell{Row: t.rows[i], Cell: flushCell})
+		}
+		delete(t.rows, i)
+	}
+	t.stages = current
+
+	t.Apply(cycle, cells)
+	return cells
+}
+
+// Adds the cells of a clock, as Record returns them
+func (t *Timeline) Apply(cycle int, cells []TimelineCell) {
+	t.Cycles = cycle
+	for _, c := range cells {
+		if c.Row == len(t.Rows) {
+			t.Rows = append(t.Rows, TimelineRow{Instruction: c.Instruction, PC: c.PC, First: cycle})
+		}
+		r := &t.Rows[c.Row]
+		for len(r.Cells) < cycle-r.First {
+			r.Cells = append(r.Cells, "")
+		}
+		r.Cells = append(r.Cells, c.Cell)
+	}
+}
+
+// Cell of the row on a clock, empty when it was not in the pipeline
+func (r TimelineRow) Cell(cycle int) string {
+	if n := cycle - r.First; n >= 0 && n < len(r.Cells) {
+		return r.Cells[n]
+	}
+	return ""
+}
+
+// Diagram of the rows from clock first to last, as text
+func timelineText(rows []TimelineRow, first, last int) string {
+	label := 0
+	for _, r := range rows {
+		label = max(label, len(r.Instruction))
+	}
+
+	var sb strings.Builder
+	line := fmt.Sprintf("%-*s", label+2, "")
+	for c := first; c <= last; c++ {
+		line += fmt.Sprintf("%-*d", timelineCellWidth, c)
+	}
+	sb.WriteString(strings.TrimRight(line, " ") + "\n")
+
+	for _, r := range rows {
+		line := fmt.Sprintf("%-*s", label+2, r.Instruction)
+		for c := first; c <= last; c++ {
+			line += fmt.Sprintf("%-*s", timelineCellWidth, r.Cell(c))
+		}
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// Whole diagram, as text
+func (t *Timeline) String() string {
+	return timelineText(t.Rows, 1, t.Cycles)
+}
+
+func writeTimeline(filename string, t *Timeline) error {
+	return os.WriteFile(filename, []byte(t.String()), 0644)
+}
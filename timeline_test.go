@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimelineStalls(t *testing.T) {
+	p := newTestPipeline(t, []string{
+		"lw R1 one(R0)",
+		"add 2 1 1",
+		"mult R1 R2",
+		"mflo R3",
+		"done halt",
+		"one .fill 1",
+	}, fullForwarding, "not-taken")
+	p.MulDiv.Latency = 4
+	runUntilHalt(t, p, 50)
+
+	want := []struct {
+		instruction string
+		first       int
+		cells       string
+	}{
+		{"lw R1 one(R0)", 1, "IF ID EX MEM WB"},
+		{"add R2 R1 R1", 2, "IF ID * EX MEM WB"},     // Load-use
+		{"mult R1 R2", 3, "IF * ID EX * * * MEM WB"}, // Held in exe
+		{"mflo R3", 5, "IF ID * * * EX MEM WB"},      // Behind mult
+		{"halt", 6, "IF * * * ID EX MEM WB"},
+		{".fill 1", 10, "IF ID X"}, // Dropped on halt
+	}
+	rows := p.Timeline.Rows
+	if len(rows) != len(want) {
+		t.Fatalf("Got %d rows, want %d:\n%s", len(rows), len(want), &p.Timeline)
+	}
+	for n, w := range want {
+		r := rows[n]
+		if r.Instruction != w.instruction || r.First != w.first || strings.Join(r.Cells, " ") != w.cells {
+			t.Errorf("Row %d = %s from %d: %v, want %s from %d: %s", n, r.Instruction, r.First, r.Cells, w.instruction, w.first, w.cells)
+		}
+	}
+}
+
+func TestTimelineFlush(t *testing.T) {
+	p := newTestPipeline(t, loopProgram, fullForwarding, "not-taken")
+	runUntilHalt(t, p, 100)
+
+	// The last beq is taken, flushing the j loop and add fetched after it
+	var flushed []string
+	for _, r := range p.Timeline.Rows[:12] {
+		if r.Cells[len(r.Cells)-1] == flushCell {
+			flushed = append(flushed, r.Instruction)
+		}
+	}
+	if strings.Join(flushed, ", ") != "j loop, add R2 R1 R2" {
+		t.Errorf("Flushed %v, want j loop and add", flushed)
+	}
+	if got := p.Timeline.Rows[10].Cell(13); got != flushCell {
+		t.Errorf("j loop on clock 13 = %q, want %s", got, flushCell)
+	}
+	if p.Timeline.Cycles != p.Cycles {
+		t.Errorf("Timeline has %d clocks, want %d", p.Timeline.Cycles, p.Cycles)
+	}
+}
+
+func TestTimelineText(t *testing.T) {
+	var tl Timeline
+	tl.Apply(1, []TimelineCell{{Row: 0, Instruction: "add R1 R2 R3", PC: 1, Cell: "IF"}})
+	tl.Apply(2, []TimelineCell{{Row: 0, Cell: "ID"}, {Row: 1, Instruction: "halt", PC: 2, Cell: "IF"}})
+	tl.Apply(3, []TimelineCell{{Row: 0, Cell: "EX"}, {Row: 1, Cell: "*"}})
+
+	want := "" +
+		"              1    2    3\n" +
+		"add R1 R2 R3  IF   ID   EX\n" +
+		"halt               IF   *\n"
+	if got := tl.String(); got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+	if got := timelineText(tl.Rows[1:], 3, 3); got != "      3\nhalt  *\n" {
+		t.Errorf("Window = %q", got)
+	}
+}
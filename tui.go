@@ -35,6 +35,9 @@ var (
 	colors = []string{"167", "168", "169", "170", "171"}
 )
 
+// Bytes shown per line on the memory view
+const memoryRowSize = 16
+
 // keyMap defines a set of keybindings. To work for help it must satisfy
 // key.Map. It could also very easily be a map[string]key.Binding.
 type keyMap struct {
@@ -42,9 +45,17 @@ type keyMap struct {
 	K    key.Binding
 	J    key.Binding
 	D    key.Binding
+	F    key.Binding
 	P    key.Binding
+	T    key.Binding
 	Help key.Binding
 	Quit key.Binding
+
+	// Scroll the timing diagram
+	Left  key.Binding
+	Right key.Binding
+	Up    key.Binding
+	Down  key.Binding
 }
 
 // ShortHelp returns keybindings to be shown in the mini help view. It's part
@@ -57,7 +68,8 @@ func (k keyMap) ShortHelp() []key.Binding {
 // key.Map interface.
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.J, k.K, k.L, k.P, k.D},
+		{k.J, k.K, k.L, k.P, k.D, k.F},
+		{k.T, k.Left, k.Right, k.Up, k.Down},
 		{k.Help, k.Quit},
 	}
 }
@@ -71,6 +83,30 @@ var keys = keyMap{
 		key.WithKeys("d", "D"),
 		key.WithHelp("d/D", "toggle debug"),
 	),
+	F: key.NewBinding(
+		key.WithKeys("f", "F"),
+		key.WithHelp("f/F", "dec/hex/bin values"),
+	),
+	T: key.NewBinding(
+		key.WithKeys("t", "T"),
+		key.WithHelp("t/T", "toggle timing diagram"),
+	),
+	Left: key.NewBinding(
+		key.WithKeys("left"),
+		key.WithHelp("←", "earlier clocks"),
+	),
+	Right: key.NewBinding(
+		key.WithKeys("right"),
+		key.WithHelp("→", "later clocks"),
+	),
+	Up: key.NewBinding(
+		key.WithKeys("["),
+		key.WithHelp("[", "older instructions"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("]"),
+		key.WithHelp("]", "newer instructions"),
+	),
 	J: key.NewBinding(
 		key.WithKeys("down", "j"),
 		key.WithHelp("↓/j", "scroll events down"),
@@ -99,12 +135,24 @@ type model struct {
 	stages        []*stage
 	messages      []string
 	messagesView  viewport.Model
-	registers     map[string]int8
+	registers     map[string]int64 // General purpose, HI and LO
+	registerCount int
+	wordWidth     int
+	format        string
+	memory        []byte
+	frames        []Frame
+	symbols       []Symbol
+	timeline      *Timeline
+	showTimeline  bool
+	timelineRow   int // Rows scrolled back from the newest
+	timelineCol   int // Clocks scrolled back from the last
 	keys          keyMap
 	help          help.Model
 	askParams     bool
 	input         textinput.Model
 	clocks        int
+	stalls        int
+	prediction    BranchStats
 	autoplay      bool
 	autoplayDelay time.Duration
 	autoplayDone  chan bool
@@ -116,14 +164,21 @@ type stage struct {
 	name     string
 	value    any
 	color    string
+	stalled  bool
 }
 
-func initModel(pipe Pipeline, regs map[string]int8) model {
+func initModel(pipe Pipeline) model {
 	pipeline = pipe
 
-	registers := make(map[string]int8)
-	for k, v := range regs {
-		registers[k] = v
+	regs := pipeline.Registers()
+	registers := make(map[string]int64)
+	for n := 0; n < regs.Count(); n++ {
+		v, _ := regs.Get(regs.Name(n))
+		registers[regs.Name(n)] = v
+	}
+	for _, name := range []string{HI, LO} {
+		v, _ := regs.Get(name)
+		registers[name] = v
 	}
 
 	stages := make([]*stage, 0)
@@ -144,14 +199,20 @@ func initModel(pipe Pipeline, regs map[string]int8) model {
 	vp.SetContent("Messages")
 
 	return model{
-		sub:          events,
-		stages:       stages,
-		registers:    registers,
-		input:        ti,
-		autoplayDone: make(chan bool),
-		keys:         keys,
-		help:         help.New(),
-		messagesView: vp,
+		sub:           events,
+		stages:        stages,
+		registers:     registers,
+		registerCount: regs.Count(),
+		wordWidth:     regs.Width(),
+		format:        displayFormat,
+		memory:        pipeline.Memory().Dump(),
+		symbols:       pipeline.Symbols(),
+		timeline:      &Timeline{},
+		input:         ti,
+		autoplayDone:  make(chan bool),
+		keys:          keys,
+		help:          help.New(),
+		messagesView:  vp,
 	}
 }
 
@@ -179,11 +240,20 @@ func toggleStages() tea.Msg {
 	return toggleStagesMsg{}
 }
 
+// Ticks outside the update loop, as the pipeline reports back through events
+func tickPipeline() tea.Msg {
+	pipeline.Tick()
+	return nil
+}
+
 func quit() tea.Msg {
 	return quitMsg{}
 }
 
 func (m model) Init() tea.Cmd {
+	if m.autoplay {
+		return tea.Batch(waitForActivity(m.sub), autoplayStages(m))
+	}
 	return waitForActivity(m.sub)
 }
 
@@ -201,17 +271,36 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case stageToggledMsg:
 		s := m.stages[msg.position]
 		s.value = msg.value
+		s.stalled = false
+
+	case stallMsg:
+		m.stalls++
+		m.stages[msg.position].stalled = true
+
+	case predictionMsg:
+		m.prediction = msg.stats
 
 	case registerUpdatedMsg:
 		m.registers[msg.name] = msg.value
 
+	case memoryUpdatedMsg:
+		copy(m.memory[msg.address:], msg.value)
+
+	case callStackMsg:
+		m.frames = msg.frames
+
+	case timelineMsg:
+		m.timeline.Apply(msg.cycle, msg.cells)
+
 	case debugMsg:
 		m.messages = append([]string{msg.message}, m.messages...)
 		m.messagesView.SetContent(strings.Join(m.messages, ""))
 
 	case toggleStagesMsg:
-		m.clocks++
-		pipeline.Broadcast('k') //TODO: Alterar para bool ou struct{}
+		return m, tea.Batch(tickPipeline, waitForActivity(m.sub))
+
+	case tickedMsg:
+		m.clocks = msg.cycles
 
 	case tea.WindowSizeMsg:
 		m.help.Width = msg.Width
@@ -256,6 +345,24 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			debug = !debug
 			Info("Debug: %v\n", debug)
 
+		case key.Matches(msg, m.keys.F):
+			m.format = nextFormat(m.format)
+
+		case key.Matches(msg, m.keys.T):
+			m.showTimeline = !m.showTimeline
+
+		case key.Matches(msg, m.keys.Left):
+			m.timelineCol = min(m.timelineCol+1, max(0, m.timeline.Cycles-1))
+
+		case key.Matches(msg, m.keys.Right):
+			m.timelineCol = max(0, m.timelineCol-1)
+
+		case key.Matches(msg, m.keys.Up):
+			m.timelineRow = min(m.timelineRow+1, max(0, len(m.timeline.Rows)-1))
+
+		case key.Matches(msg, m.keys.Down):
+			m.timelineRow = max(0, m.timelineRow-1)
+
 		case key.Matches(msg, m.keys.L):
 			return m, toggleStages
 
@@ -296,9 +403,36 @@ func (m model) View() string {
 	sb.WriteString(m.registersView())
 	sb.WriteString("\n\n")
 
+	// Memória
+	sb.WriteString(m.headerView("Memory") + "\n")
+	sb.WriteString(m.memoryView())
+	sb.WriteString("\n\n")
+
+	// Tabela de símbolos
+	if len(m.symbols) > 0 {
+		sb.WriteString(m.headerView("Symbols") + "\n")
+		sb.WriteString(m.symbolsView())
+		sb.WriteString("\n\n")
+	}
+
+	// Pilha de chamadas
+	sb.WriteString(m.headerView("Call stack") + "\n")
+	sb.WriteString(m.callStackView())
+	sb.WriteString("\n\n")
+
 	// Estágios
 	sb.WriteString(m.stagesView())
 
+	// Código de máquina do estágio dec
+	sb.WriteString(m.decodeView())
+
+	// Diagrama do pipeline
+	if m.showTimeline {
+		sb.WriteString(m.headerView("Timing diagram") + "\n")
+		sb.WriteString(m.timelineView())
+		sb.WriteString("\n\n")
+	}
+
 	// Eventos
 	sb.WriteString(m.eventsView())
 
@@ -327,45 +461,172 @@ func (m model) informationView() string {
 		s += inactiveStyle.Render("   off")
 	}
 
-	s += fmt.Sprintf("\nClocks:   %d\n\n", m.clocks)
+	s += "\nWidth: " + activeStyle.Render(fmt.Sprintf("%d bits (%s)", m.wordWidth, m.format))
+
+	s += "\nForwarding: " + activeStyle.Render(forwarding.String())
+
+	s += "\nMul/div: " + activeStyle.Render(fmt.Sprintf("   %d clocks", mulDivLatency))
+
+	s += "\nPredictor: " + activeStyle.Render(predictorName)
+	if b := m.prediction; b.Branches > 0 {
+		s += fmt.Sprintf(" (%d/%d, %.0f%% accuracy)", b.Branches-b.Mispredicted, b.Branches, 100*b.Accuracy())
+	}
+
+	s += fmt.Sprintf("\nClocks:   %d", m.clocks)
+	s += fmt.Sprintf("\nStalls:   %d", m.stalls)
+	s += fmt.Sprintf("\nFlushes:  %d cycles\n\n", m.prediction.Flushed)
 
 	return s
 }
 
+// Registers are split in rows when the values in the chosen format do not
+// fit the terminal side by side
 func (m model) registersView() string {
-	registerStyle := lipgloss.NewStyle().Width(m.width / len(m.registers))
+	cell := max(5, len(formatWord(-1<<(m.wordWidth-1), m.wordWidth, m.format))) + 2
+	perRow := max(1, min(m.registerCount, (m.width-8)/cell))
+	registerStyle := lipgloss.NewStyle().Width(cell)
 
-	s := "Name\t"
-	for i := 0; i < len(m.registers); i++ {
-		name := fmt.Sprintf("R%d", i)
-		value := fmt.Sprintf("R%02d  ", i)
+	render := func(name, value string) string {
 		if m.registers[name] != 0 {
-			s += registerStyle.Copy().Inherit(activeStyle).Render(value)
-		} else {
-			s += registerStyle.Copy().Inherit(inactiveStyle).Render(value)
+			return registerStyle.Copy().Inherit(activeStyle).Render(value)
 		}
+		return registerStyle.Copy().Inherit(inactiveStyle).Render(value)
 	}
-	s += "\n"
 
-	s += "Value\t"
-	for i := 0; i < len(m.registers); i++ {
-		name := fmt.Sprintf("R%d", i)
-		value := fmt.Sprintf("%3d  ", m.registers[name])
-		if m.registers[name] != 0 {
-			s += registerStyle.Copy().Inherit(activeStyle).Render(value)
-		} else {
-			s += registerStyle.Copy().Inherit(inactiveStyle).Render(value)
+	rows := make([]string, 0)
+	for first := 0; first < m.registerCount; first += perRow {
+		last := min(first+perRow, m.registerCount)
+
+		s := "Name\t"
+		for i := first; i < last; i++ {
+			s += render(fmt.Sprintf("R%d", i), fmt.Sprintf("R%02d", i))
+		}
+		s += "\nABI\t"
+		for i := first; i < last; i++ {
+			s += render(fmt.Sprintf("R%d", i), abiName(i))
 		}
+		s += "\nValue\t"
+		for i := first; i < last; i++ {
+			name := fmt.Sprintf("R%d", i)
+			s += render(name, formatWord(m.registers[name], m.wordWidth, m.format))
+		}
+		rows = append(rows, s)
 	}
 
-	return s
+	// Written by the multiply and divide unit only
+	s := "Name\t" + render(HI, HI) + render(LO, LO)
+	s += "\nValue\t" + render(HI, formatWord(m.registers[HI], m.wordWidth, m.format)) + render(LO, formatWord(m.registers[LO], m.wordWidth, m.format))
+	rows = append(rows, s)
+
+	return strings.Join(rows, "\n\n")
+}
+
+// Only lines with some non zero byte are shown
+func (m model) memoryView() string {
+	lines := make([]string, 0)
+	for addr := 0; addr < len(m.memory); addr += memoryRowSize {
+		row := m.memory[addr:min(addr+memoryRowSize, len(m.memory))]
+
+		used := false
+		s := fmt.Sprintf("%04X\t", addr)
+		for _, b := range row {
+			value := fmt.Sprintf("%02X ", b)
+			if b != 0 {
+				used = true
+				s += activeStyle.Render(value)
+			} else {
+				s += inactiveStyle.Render(value)
+			}
+		}
+
+		if used {
+			lines = append(lines, s)
+		}
+	}
+
+	if len(lines) == 0 {
+		return inactiveStyle.Render("Empty")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Labels of both segments, side by side while they fit the terminal
+func (m model) symbolsView() string {
+	lines := make([]string, 0)
+	line := ""
+	for _, s := range m.symbols {
+		cell := s.String()
+		if line != "" && len(line)+len(cell)+3 > m.width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += "   "
+		}
+		line += cell
+	}
+	lines = append(lines, line)
+	return strings.Join(lines, "\n")
+}
+
+// Frames from the innermost, with the words each callee pushed below the
+// stack pointer it was called with, from the first pushed
+func (m model) callStackView() string {
+	sp := int(NewALU(m.wordWidth).unsigned(m.registers[stackPointer]))
+
+	lines := make([]string, 0, len(m.frames)+1)
+	for n := len(m.frames) - 1; n >= 0; n-- {
+		f := m.frames[n]
+		bottom := sp
+		if n+1 < len(m.frames) {
+			bottom = m.frames[n+1].SP
+		}
+		s := fmt.Sprintf("#%d\t%v, $sp %04X", n+1, f, f.SP)
+		if words := m.stackWords(bottom, f.SP); words != "" {
+			s += "\t[" + words + "]"
+		}
+		lines = append(lines, activeStyle.Render(s))
+	}
+
+	if len(m.frames) > 0 {
+		sp = m.frames[0].SP
+	}
+	lines = append(lines, inactiveStyle.Render(fmt.Sprintf("#0\tmain, $sp %04X", sp)))
+	return strings.Join(lines, "\n")
+}
+
+// Words from the one right below top down to bottom
+func (m model) stackWords(bottom, top int) string {
+	mem := &Memory{data: m.memory, order: memoryOrder}
+	words := make([]string, 0)
+	for addr := top - wordSize; addr >= bottom; addr -= wordSize {
+		v, err := mem.Load(addr, wordSize)
+		if err != nil {
+			break
+		}
+		words = append(words, formatWord(int64(v), m.wordWidth, m.format))
+	}
+	return strings.Join(words, " ")
 }
 
 func (m model) stagesView() string {
 	s := m.headerView("Stages") + "\n\n"
 
 	for _, stage := range m.stages {
-		st := fmt.Sprintf("[%s] %v \t\t", stage.nickname, stage.value)
+		value := stage.value
+		if value == nil {
+			value = ""
+		}
+		st := fmt.Sprintf("[%s] %v \t\t", stage.nickname, value)
+		i, ok := value.(*Instruction)
+		switch {
+		case stage.stalled:
+			st = fmt.Sprintf("[%s] %v (stall) \t", stage.nickname, value)
+		case ok && i.Flushed:
+			st = fmt.Sprintf("[%s] %v (flushed) \t", stage.nickname, value)
+		case ok && stage.nickname == "exe" && len(i.Forwarded) > 0:
+			st = fmt.Sprintf("[%s] %v (%s) \t", stage.nickname, value, forwardedView(i))
+		}
 
 		style := stageStyle.
 			Width(m.width / len(m.stages)).
@@ -377,6 +638,55 @@ func (m model) stagesView() string {
 	return s
 }
 
+// Fields of the machine code of the instruction in dec, in binary and decimal
+func (m model) decodeView() string {
+	var i *Instruction
+	for _, stage := range m.stages {
+		if stage.nickname == "dec" {
+			i, _ = stage.value.(*Instruction)
+		}
+	}
+	if i == nil || i.Encoding.Format == 0 {
+		return ""
+	}
+
+	s := m.headerView("Decode") + "\n"
+	s += fmt.Sprintf("%v: %c-type %s\n", i, i.Encoding.Format, activeStyle.Render(i.Encoding.String()))
+	names, bits, values := "", "", ""
+	for _, f := range i.Encoding.Fields() {
+		cell := max(f.Bits, len(f.Name)) + 2
+		names += fmt.Sprintf("%-*s", cell, f.Name)
+		bits += fmt.Sprintf("%-*s", cell, fmt.Sprintf("%0*b", f.Bits, f.Value))
+		values += fmt.Sprintf("%-*d", cell, f.Value)
+	}
+	s += names + "\n" + activeStyle.Render(bits) + "\n" + values + "\n\n"
+	return s
+}
+
+// Rows of the timing diagram shown at once
+const timelineRows = 12
+
+// Window of the timing diagram that fits the terminal, following the last
+// clock unless scrolled back
+func (m model) timelineView() string {
+	t := m.timeline
+	if len(t.Rows) == 0 {
+		return inactiveStyle.Render("Empty")
+	}
+
+	end := len(t.Rows) - m.timelineRow
+	rows := t.Rows[max(0, end-timelineRows):end]
+	label := 0
+	for _, r := range rows {
+		label = max(label, len(r.Instruction))
+	}
+	clocks := max(1, (m.width-label-2)/timelineCellWidth)
+	last := t.Cycles - m.timelineCol
+	first := max(1, last-clocks+1)
+
+	return strings.TrimSuffix(timelineText(rows, first, last), "\n")
+}
+
 func (m model) eventsView() string {
 	s := m.headerView("Events") + "\n"
 	filtered := make([]string, 0)
@@ -409,9 +719,15 @@ func (m model) footerView() string {
 	return lipgloss.JoinHorizontal(lipgloss.Center, line, info)
 }
 
-func RunCmd(pipe Pipeline, regs map[string]int8, events chan interface{}) {
+// Autoplay starts right away when given a delay
+func RunCmd(pipe Pipeline, events chan interface{}, autoplay time.Duration) {
+	m := initModel(pipe)
+	if autoplay > 0 {
+		m.autoplay = true
+		m.autoplayDelay = autoplay
+	}
 
-	p := tea.NewProgram(initModel(pipe, regs))
+	p := tea.NewProgram(m)
 
 	if _, err := p.Run(); err != nil {
 		fmt.Println("could not start program:", err)